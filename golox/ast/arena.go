@@ -0,0 +1,45 @@
+package ast
+
+import "math/bits"
+
+// Chunks start out small, so that parsing a single line in the REPL doesn't allocate much, and double in size
+// with the number of values up to maxChunkSize
+const (
+	minChunkSize = 8
+	maxChunkSize = 1024
+)
+
+// Allocates values in chunks that are never reallocated, unlike the backing array of a slice that is appended
+// to. Pointers to the values therefore stay valid no matter how many values are allocated after them.
+type arena[T any] struct {
+	chunks [][]T
+	len    int
+}
+
+// Allocates a copy of value in the arena, and returns a pointer to it and its index
+func (a *arena[T]) alloc(value T) (*T, int) {
+	if len(a.chunks) == 0 || len(a.chunks[len(a.chunks)-1]) == cap(a.chunks[len(a.chunks)-1]) {
+		a.chunks = append(a.chunks, make([]T, 0, min(max(a.len, minChunkSize), maxChunkSize)))
+	}
+	chunk := &a.chunks[len(a.chunks)-1]
+	*chunk = append(*chunk, value)
+	a.len += 1
+	return &(*chunk)[len(*chunk)-1], a.len - 1
+}
+
+// Returns a pointer to the value with the given index, which counts the values in the order of allocation
+func (a *arena[T]) get(index int) *T {
+	if index < maxChunkSize {
+		// Chunk k > 0 starts at index minChunkSize << (k-1), as it is as large as all chunks before it
+		chunk := bits.Len(uint(index / minChunkSize))
+		start := 0
+		if chunk > 0 {
+			start = minChunkSize << (chunk - 1)
+		}
+		return &a.chunks[chunk][index-start]
+	}
+
+	// The chunks before the first one of maxChunkSize hold maxChunkSize values in total
+	growing := bits.Len(maxChunkSize/minChunkSize) - 1
+	return &a.chunks[growing+index/maxChunkSize][index%maxChunkSize]
+}
@@ -1,7 +1,20 @@
 package ast
 
+// A parsed program. Its nodes are allocated by the stores, which assign each of them an ID that is unique
+// within the Ast. Nodes refer to their children by these IDs, so they can only be interpreted together with
+// the Ast they belong to.
 type Ast struct {
-	Body        []Stmt
+	Body        []StmtID
 	Statements  StmtStore
 	Expressions ExprStore
 }
+
+// Returns the expression with the given ID, or nil for the ID 0
+func (a *Ast) Expr(id ExprID) Expr {
+	return a.Expressions.Get(id)
+}
+
+// Returns the statement with the given ID, or nil for the ID 0
+func (a *Ast) Stmt(id StmtID) Stmt {
+	return a.Statements.Get(id)
+}
@@ -1,134 +1,359 @@
 package ast
 
-// Tag interface for Expression types
+// Tag interface for Expression types. Expressions refer to their children by ExprID, see Ast.Expr().
 type Expr interface {
 	isExpr()
+	// Returns the ID the ExprStore assigned to the expression
+	ID() ExprID
 }
 
 type LiteralExpr struct {
+	exprNode
 	Token Token
 }
 
 func (e LiteralExpr) isExpr() {}
 
 type UnaryExpr struct {
+	exprNode
 	Operator Token
-	Operand  Expr
+	Operand  ExprID
 }
 
 func (e UnaryExpr) isExpr() {}
 
 type BinaryExpr struct {
+	exprNode
 	Operator Token
-	Left     Expr
-	Right    Expr
+	Left     ExprID
+	Right    ExprID
 }
 
 func (e BinaryExpr) isExpr() {}
 
+// A sequence of comma-separated expressions, which are evaluated from left to right. The value of the
+// sequence is that of the last expression.
+type SequenceExpr struct {
+	exprNode
+	Exprs []ExprID
+}
+
+func (e SequenceExpr) isExpr() {}
+
+// The conditional operator cond ? then : else
+type ConditionalExpr struct {
+	exprNode
+	Question  Token
+	Condition ExprID
+	Then      ExprID
+	Else      ExprID
+}
+
+func (e ConditionalExpr) isExpr() {}
+
 type GroupingExpr struct {
-	Grouped Expr
+	exprNode
+	Grouped ExprID
 }
 
 func (e GroupingExpr) isExpr() {}
 
 type IdentifierExpr struct {
+	exprNode
 	Token Token
 }
 
 func (e IdentifierExpr) isExpr() {}
 
 type AssignExpr struct {
+	exprNode
 	Target Token
-	Value  Expr
+	Value  ExprID
 }
 
 func (e AssignExpr) isExpr() {}
 
+// An assignment combined with a binary operator, e.g. a += 1
+type CompoundAssignExpr struct {
+	exprNode
+	Target   Token
+	Operator Token // One of PLUS_EQUAL, MINUS_EQUAL, STAR_EQUAL, SLASH_EQUAL
+	Value    ExprID
+}
+
+func (e CompoundAssignExpr) isExpr() {}
+
+// Increment or decrement of a variable, either before (++a) or after (a++) its value is taken
+type IncrementExpr struct {
+	exprNode
+	Target   Token
+	Operator Token // PLUS_PLUS or MINUS_MINUS
+	Prefix   bool
+}
+
+func (e IncrementExpr) isExpr() {}
+
 type OrExpr struct {
-	Left  Expr
-	Right Expr
+	exprNode
+	Left  ExprID
+	Right ExprID
 }
 
 func (e OrExpr) isExpr() {}
 
 type AndExpr struct {
-	Left  Expr
-	Right Expr
+	exprNode
+	Left  ExprID
+	Right ExprID
 }
 
 func (e AndExpr) isExpr() {}
 
 type CallExpr struct {
+	exprNode
 	Location  Token
-	Callee    Expr
-	Arguments []Expr
+	Callee    ExprID
+	Arguments []ExprID
 }
 
 func (e CallExpr) isExpr() {}
 
+type GetExpr struct {
+	exprNode
+	Object ExprID
+	Name   Token
+}
+
+func (e GetExpr) isExpr() {}
+
+// An anonymous function
+type FunctionExpr struct {
+	exprNode
+	Keyword Token
+	FunctionBody
+}
+
+func (e FunctionExpr) isExpr() {}
+
+// Starts a function call that runs concurrently and evaluates to a task handle
+type SpawnExpr struct {
+	exprNode
+	Keyword Token
+	Call    ExprID // Always a CallExpr
+}
+
+func (e SpawnExpr) isExpr() {}
+
+// Waits for a task to finish and evaluates to its result
+type AwaitExpr struct {
+	exprNode
+	Keyword Token
+	Task    ExprID
+}
+
+func (e AwaitExpr) isExpr() {}
+
+// Allocates the expressions of an Ast. Expressions are allocated in chunks that are never moved, so the
+// pointers returned by the New...() functions stay valid however many expressions are allocated after them.
+// Each expression is also assigned an ExprID, which Get() resolves back to the expression.
 type ExprStore struct {
-	Literal    []LiteralExpr
-	Unary      []UnaryExpr
-	Binary     []BinaryExpr
-	Grouping   []GroupingExpr
-	Identifier []IdentifierExpr
-	Assign     []AssignExpr
-	Or         []OrExpr
-	And        []AndExpr
-	Call       []CallExpr
+	literal     arena[LiteralExpr]
+	unary       arena[UnaryExpr]
+	binary      arena[BinaryExpr]
+	sequence    arena[SequenceExpr]
+	conditional arena[ConditionalExpr]
+	grouping    arena[GroupingExpr]
+	identifier  arena[IdentifierExpr]
+	assign      arena[AssignExpr]
+	compound    arena[CompoundAssignExpr]
+	increment   arena[IncrementExpr]
+	or          arena[OrExpr]
+	and         arena[AndExpr]
+	call        arena[CallExpr]
+	get         arena[GetExpr]
+	function    arena[FunctionExpr]
+	spawn       arena[SpawnExpr]
+	await       arena[AwaitExpr]
+
+	refs []nodeRef // Locates each expression in its arena, indexed by ID - 1
+}
+
+// Kinds of expressions, which select the arena a nodeRef points into
+const (
+	literalExpr nodeKind = iota + 1
+	unaryExpr
+	binaryExpr
+	sequenceExpr
+	conditionalExpr
+	groupingExpr
+	identifierExpr
+	assignExpr
+	compoundExpr
+	incrementExpr
+	orExpr
+	andExpr
+	callExpr
+	getExpr
+	functionExpr
+	spawnExpr
+	awaitExpr
+)
+
+// Registers a newly allocated expression and returns its ID
+func (es *ExprStore) add(kind nodeKind, index int) ExprID {
+	es.refs = append(es.refs, nodeRef{kind: kind, index: uint32(index)})
+	return ExprID(len(es.refs))
+}
+
+// Returns the expression with the given ID, which must have been assigned by this store, or nil for the ID 0
+func (es *ExprStore) Get(id ExprID) Expr {
+	if id == 0 {
+		return nil
+	}
+	ref := es.refs[id-1]
+	index := int(ref.index)
+	switch ref.kind {
+	case literalExpr:
+		return es.literal.get(index)
+	case unaryExpr:
+		return es.unary.get(index)
+	case binaryExpr:
+		return es.binary.get(index)
+	case sequenceExpr:
+		return es.sequence.get(index)
+	case conditionalExpr:
+		return es.conditional.get(index)
+	case groupingExpr:
+		return es.grouping.get(index)
+	case identifierExpr:
+		return es.identifier.get(index)
+	case assignExpr:
+		return es.assign.get(index)
+	case compoundExpr:
+		return es.compound.get(index)
+	case incrementExpr:
+		return es.increment.get(index)
+	case orExpr:
+		return es.or.get(index)
+	case andExpr:
+		return es.and.get(index)
+	case callExpr:
+		return es.call.get(index)
+	case getExpr:
+		return es.get.get(index)
+	case functionExpr:
+		return es.function.get(index)
+	case spawnExpr:
+		return es.spawn.get(index)
+	case awaitExpr:
+		return es.await.get(index)
+	}
+	panic("unknown expression kind")
+}
+
+// Returns the number of expressions allocated so far, which is also the largest ID assigned
+func (es *ExprStore) Len() int {
+	return len(es.refs)
 }
 
 func (es *ExprStore) NewLiteralExpr(token Token) *LiteralExpr {
-	idx := len(es.Literal)
-	es.Literal = append(es.Literal, LiteralExpr{Token: token})
-	return &es.Literal[idx]
+	expr, index := es.literal.alloc(LiteralExpr{Token: token})
+	expr.id = es.add(literalExpr, index)
+	return expr
+}
+
+func (es *ExprStore) NewUnaryExpr(operator Token, operand ExprID) *UnaryExpr {
+	expr, index := es.unary.alloc(UnaryExpr{Operator: operator, Operand: operand})
+	expr.id = es.add(unaryExpr, index)
+	return expr
+}
+
+func (es *ExprStore) NewBinaryExpr(operator Token, left ExprID, right ExprID) *BinaryExpr {
+	expr, index := es.binary.alloc(BinaryExpr{Operator: operator, Left: left, Right: right})
+	expr.id = es.add(binaryExpr, index)
+	return expr
 }
 
-func (es *ExprStore) NewUnaryExpr(operator Token, operand Expr) *UnaryExpr {
-	idx := len(es.Unary)
-	es.Unary = append(es.Unary, UnaryExpr{Operator: operator, Operand: operand})
-	return &es.Unary[idx]
+func (es *ExprStore) NewSequenceExpr(exprs []ExprID) *SequenceExpr {
+	expr, index := es.sequence.alloc(SequenceExpr{Exprs: exprs})
+	expr.id = es.add(sequenceExpr, index)
+	return expr
 }
 
-func (es *ExprStore) NewBinaryExpr(operator Token, left Expr, right Expr) *BinaryExpr {
-	idx := len(es.Binary)
-	es.Binary = append(es.Binary, BinaryExpr{Operator: operator, Left: left, Right: right})
-	return &es.Binary[idx]
+func (es *ExprStore) NewConditionalExpr(question Token, condition ExprID, then ExprID, else_ ExprID) *ConditionalExpr {
+	expr, index := es.conditional.alloc(ConditionalExpr{Question: question, Condition: condition, Then: then, Else: else_})
+	expr.id = es.add(conditionalExpr, index)
+	return expr
 }
 
-func (es *ExprStore) NewGroupingExpr(grouped Expr) *GroupingExpr {
-	idx := len(es.Grouping)
-	es.Grouping = append(es.Grouping, GroupingExpr{Grouped: grouped})
-	return &es.Grouping[idx]
+func (es *ExprStore) NewGroupingExpr(grouped ExprID) *GroupingExpr {
+	expr, index := es.grouping.alloc(GroupingExpr{Grouped: grouped})
+	expr.id = es.add(groupingExpr, index)
+	return expr
 }
 
 func (es *ExprStore) NewIdentifierExpr(token Token) *IdentifierExpr {
-	idx := len(es.Identifier)
-	es.Identifier = append(es.Identifier, IdentifierExpr{Token: token})
-	return &es.Identifier[idx]
+	expr, index := es.identifier.alloc(IdentifierExpr{Token: token})
+	expr.id = es.add(identifierExpr, index)
+	return expr
+}
+
+func (es *ExprStore) NewAssignExpr(target Token, value ExprID) *AssignExpr {
+	expr, index := es.assign.alloc(AssignExpr{Target: target, Value: value})
+	expr.id = es.add(assignExpr, index)
+	return expr
+}
+
+func (es *ExprStore) NewCompoundAssignExpr(target Token, operator Token, value ExprID) *CompoundAssignExpr {
+	expr, index := es.compound.alloc(CompoundAssignExpr{Target: target, Operator: operator, Value: value})
+	expr.id = es.add(compoundExpr, index)
+	return expr
+}
+
+func (es *ExprStore) NewIncrementExpr(target Token, operator Token, prefix bool) *IncrementExpr {
+	expr, index := es.increment.alloc(IncrementExpr{Target: target, Operator: operator, Prefix: prefix})
+	expr.id = es.add(incrementExpr, index)
+	return expr
+}
+
+func (es *ExprStore) NewOrExpr(left ExprID, right ExprID) *OrExpr {
+	expr, index := es.or.alloc(OrExpr{Left: left, Right: right})
+	expr.id = es.add(orExpr, index)
+	return expr
+}
+
+func (es *ExprStore) NewAndExpr(left ExprID, right ExprID) *AndExpr {
+	expr, index := es.and.alloc(AndExpr{Left: left, Right: right})
+	expr.id = es.add(andExpr, index)
+	return expr
+}
+
+func (es *ExprStore) NewCallExpr(location Token, callee ExprID, arguments []ExprID) *CallExpr {
+	expr, index := es.call.alloc(CallExpr{Location: location, Callee: callee, Arguments: arguments})
+	expr.id = es.add(callExpr, index)
+	return expr
 }
 
-func (es *ExprStore) NewAssignExpr(target Token, value Expr) *AssignExpr {
-	idx := len(es.Assign)
-	es.Assign = append(es.Assign, AssignExpr{Target: target, Value: value})
-	return &es.Assign[idx]
+func (es *ExprStore) NewGetExpr(object ExprID, name Token) *GetExpr {
+	expr, index := es.get.alloc(GetExpr{Object: object, Name: name})
+	expr.id = es.add(getExpr, index)
+	return expr
 }
 
-func (es *ExprStore) NewOrExpr(left Expr, right Expr) *OrExpr {
-	idx := len(es.Or)
-	es.Or = append(es.Or, OrExpr{Left: left, Right: right})
-	return &es.Or[idx]
+func (es *ExprStore) NewFunctionExpr(keyword Token, function FunctionBody) *FunctionExpr {
+	expr, index := es.function.alloc(FunctionExpr{Keyword: keyword, FunctionBody: function})
+	expr.id = es.add(functionExpr, index)
+	return expr
 }
 
-func (es *ExprStore) NewAndExpr(left Expr, right Expr) *AndExpr {
-	idx := len(es.And)
-	es.And = append(es.And, AndExpr{Left: left, Right: right})
-	return &es.And[idx]
+func (es *ExprStore) NewSpawnExpr(keyword Token, call ExprID) *SpawnExpr {
+	expr, index := es.spawn.alloc(SpawnExpr{Keyword: keyword, Call: call})
+	expr.id = es.add(spawnExpr, index)
+	return expr
 }
 
-func (es *ExprStore) NewCallExpr(location Token, callee Expr, arguments []Expr) *CallExpr {
-	idx := len(es.Call)
-	es.Call = append(es.Call, CallExpr{Location: location, Callee: callee, Arguments: arguments})
-	return &es.Call[idx]
+func (es *ExprStore) NewAwaitExpr(keyword Token, task ExprID) *AwaitExpr {
+	expr, index := es.await.alloc(AwaitExpr{Keyword: keyword, Task: task})
+	expr.id = es.add(awaitExpr, index)
+	return expr
 }
@@ -0,0 +1,618 @@
+package ast
+
+import (
+	"encoding/json"
+	"fmt"
+	"toterich/golox/util/assert"
+)
+
+// Version of the JSON format written by EncodeJSON(). It is increased whenever the format changes in a way that
+// tools reading it need to know about.
+const JSONVersion = 1
+
+// The JSON representation of a program. Every statement and expression is a jsonNode whose Node field names
+// its type, e.g. "WhileStmt", and which only has the fields of that type set. The fields are named like those
+// of the Go types.
+type jsonProgram struct {
+	Version int         `json:"version"`
+	Body    []*jsonNode `json:"body"`
+}
+
+type jsonNode struct {
+	Node string `json:"node"`
+	Line int    `json:"line,omitempty"` // Of statements only
+
+	Token      *jsonToken      `json:"token,omitempty"`
+	Operator   *jsonToken      `json:"operator,omitempty"`
+	Keyword    *jsonToken      `json:"keyword,omitempty"`
+	Question   *jsonToken      `json:"question,omitempty"`
+	Location   *jsonToken      `json:"location,omitempty"`
+	Target     *jsonToken      `json:"target,omitempty"`
+	Name       *jsonToken      `json:"name,omitempty"`
+	Identifier *jsonToken      `json:"identifier,omitempty"`
+	Type       *jsonAnnotation `json:"type,omitempty"`
+	Prefix     bool            `json:"prefix,omitempty"`
+
+	Operand   *jsonNode   `json:"operand,omitempty"`
+	Left      *jsonNode   `json:"left,omitempty"`
+	Right     *jsonNode   `json:"right,omitempty"`
+	Exprs     []*jsonNode `json:"exprs,omitempty"`
+	Grouped   *jsonNode   `json:"grouped,omitempty"`
+	Callee    *jsonNode   `json:"callee,omitempty"`
+	Arguments []*jsonNode `json:"arguments,omitempty"`
+	Object    *jsonNode   `json:"object,omitempty"`
+	Call      *jsonNode   `json:"call,omitempty"`
+	Task      *jsonNode   `json:"task,omitempty"`
+	Expr      *jsonNode   `json:"expr,omitempty"`
+	Condition *jsonNode   `json:"condition,omitempty"`
+	Value     *jsonNode   `json:"value,omitempty"`
+
+	Params     []jsonToken       `json:"params,omitempty"`
+	ParamTypes []*jsonAnnotation `json:"paramTypes,omitempty"`
+	ReturnType *jsonAnnotation   `json:"returnType,omitempty"`
+	Body       json.RawMessage   `json:"body,omitempty"` // A statement, or a list of them for blocks and functions
+
+	Then      *jsonNode  `json:"then,omitempty"`
+	Else      *jsonNode  `json:"else,omitempty"`
+	Increment *jsonNode  `json:"increment,omitempty"`
+	Cases     []jsonCase `json:"cases,omitempty"`
+	Default   *jsonNode  `json:"default,omitempty"`
+	CatchName *jsonToken `json:"catchName,omitempty"`
+	Catch     *jsonNode  `json:"catch,omitempty"`
+	Finally   *jsonNode  `json:"finally,omitempty"`
+}
+
+type jsonToken struct {
+	Type    string `json:"type"` // Name of the TokenType, e.g. IDENTIFIER
+	Lexeme  string `json:"lexeme"`
+	Line    int    `json:"line"`
+	Literal any    `json:"literal,omitempty"` // The string, number or bool value of literal tokens
+}
+
+type jsonAnnotation struct {
+	Name jsonToken `json:"name"`
+}
+
+type jsonCase struct {
+	Values []*jsonNode `json:"values"`
+	Body   *jsonNode   `json:"body"`
+}
+
+// Encodes the body of a program as indented JSON. The result can be decoded with DecodeJSON().
+func EncodeJSON(tree *Ast) ([]byte, error) {
+	e := jsonEncoder{tree: tree}
+	program := jsonProgram{Version: JSONVersion, Body: []*jsonNode{}}
+	for _, stmt := range tree.Body {
+		program.Body = append(program.Body, e.stmt(stmt))
+	}
+	return json.MarshalIndent(program, "", "  ")
+}
+
+// Resolves the IDs of child nodes while encoding them
+type jsonEncoder struct {
+	tree *Ast
+}
+
+func encodeToken(token Token) *jsonToken {
+	encoded := &jsonToken{Type: token.Type.String(), Lexeme: token.Lexeme, Line: token.Line}
+	switch token.Literal.Type {
+	case LT_STRING:
+		encoded.Literal = token.Literal.AsString()
+	case LT_NUMBER:
+		encoded.Literal = token.Literal.AsNumber()
+	case LT_BOOL:
+		encoded.Literal = token.Literal.AsBool()
+	}
+	return encoded
+}
+
+// Returns nil for tokens that are absent, like the catch name of a try statement without catch clause
+func encodeOptionalToken(token Token) *jsonToken {
+	if token == (Token{}) {
+		return nil
+	}
+	return encodeToken(token)
+}
+
+func encodeAnnotation(annotation *TypeAnnotation) *jsonAnnotation {
+	if annotation == nil {
+		return nil
+	}
+	return &jsonAnnotation{Name: *encodeToken(annotation.Name)}
+}
+
+func (e jsonEncoder) stmts(stmts []StmtID) json.RawMessage {
+	nodes := []*jsonNode{}
+	for _, stmt := range stmts {
+		nodes = append(nodes, e.stmt(stmt))
+	}
+	data, err := json.Marshal(nodes)
+	assert.Assert(err == nil, "statements can always be encoded")
+	return data
+}
+
+func (e jsonEncoder) body(stmt StmtID) json.RawMessage {
+	data, err := json.Marshal(e.stmt(stmt))
+	assert.Assert(err == nil, "statements can always be encoded")
+	return data
+}
+
+func (e jsonEncoder) setFunction(n *jsonNode, function FunctionBody) {
+	for _, param := range function.Params {
+		n.Params = append(n.Params, *encodeToken(param))
+	}
+	for _, paramType := range function.ParamTypes {
+		n.ParamTypes = append(n.ParamTypes, encodeAnnotation(paramType))
+	}
+	n.ReturnType = encodeAnnotation(function.ReturnType)
+	n.Body = e.stmts(function.Body)
+}
+
+// Returns nil for the ID 0 of absent statements
+func (e jsonEncoder) stmt(id StmtID) *jsonNode {
+	if id == 0 {
+		return nil
+	}
+
+	stmt := e.tree.Stmt(id)
+	n := &jsonNode{Line: stmt.StartLine()}
+	switch stmt := stmt.(type) {
+	case *ExprStmt:
+		n.Node, n.Expr = "ExprStmt", e.expr(stmt.Expr)
+	case *PrintStmt:
+		n.Node, n.Expr = "PrintStmt", e.expr(stmt.Expr)
+	case *VarDeclStmt:
+		n.Node = "VarDeclStmt"
+		n.Identifier, n.Type, n.Value = encodeToken(stmt.Identifier), encodeAnnotation(stmt.Type), e.expr(stmt.Value)
+	case *BlockStmt:
+		n.Node, n.Body = "BlockStmt", e.stmts(stmt.Body)
+	case *IfStmt:
+		n.Node = "IfStmt"
+		n.Condition, n.Then, n.Else = e.expr(stmt.Condition), e.stmt(stmt.Then), e.stmt(stmt.Else)
+	case *WhileStmt:
+		n.Node = "WhileStmt"
+		n.Condition, n.Then, n.Increment = e.expr(stmt.Condition), e.stmt(stmt.Then), e.expr(stmt.Increment)
+	case *SwitchStmt:
+		n.Node = "SwitchStmt"
+		n.Keyword, n.Value, n.Default = encodeToken(stmt.Keyword), e.expr(stmt.Value), e.stmt(stmt.Default)
+		for _, case_ := range stmt.Cases {
+			encoded := jsonCase{Values: []*jsonNode{}, Body: e.stmt(case_.Body)}
+			for _, value := range case_.Values {
+				encoded.Values = append(encoded.Values, e.expr(value))
+			}
+			n.Cases = append(n.Cases, encoded)
+		}
+	case *BreakStmt:
+		n.Node = "BreakStmt"
+	case *ContinueStmt:
+		n.Node = "ContinueStmt"
+	case *FunDeclStmt:
+		n.Node, n.Name = "FunDeclStmt", encodeToken(stmt.Name)
+		e.setFunction(n, stmt.FunctionBody)
+	case *ReturnStmt:
+		n.Node, n.Keyword, n.Value = "ReturnStmt", encodeToken(stmt.Keyword), e.expr(stmt.Value)
+	case *ThrowStmt:
+		n.Node, n.Keyword, n.Value = "ThrowStmt", encodeToken(stmt.Keyword), e.expr(stmt.Value)
+	case *TryStmt:
+		n.Node, n.Body = "TryStmt", e.body(stmt.Body)
+		n.CatchName, n.Catch, n.Finally = encodeOptionalToken(stmt.CatchName), e.stmt(stmt.Catch), e.stmt(stmt.Finally)
+	default:
+		panic(assert.MissingCase(stmt))
+	}
+	return n
+}
+
+// Returns nil for the ID 0 of absent expressions
+func (e jsonEncoder) expr(id ExprID) *jsonNode {
+	if id == 0 {
+		return nil
+	}
+
+	n := &jsonNode{}
+	switch expr := e.tree.Expr(id).(type) {
+	case *LiteralExpr:
+		n.Node, n.Token = "LiteralExpr", encodeToken(expr.Token)
+	case *UnaryExpr:
+		n.Node, n.Operator, n.Operand = "UnaryExpr", encodeToken(expr.Operator), e.expr(expr.Operand)
+	case *BinaryExpr:
+		n.Node, n.Operator = "BinaryExpr", encodeToken(expr.Operator)
+		n.Left, n.Right = e.expr(expr.Left), e.expr(expr.Right)
+	case *SequenceExpr:
+		n.Node = "SequenceExpr"
+		for _, child := range expr.Exprs {
+			n.Exprs = append(n.Exprs, e.expr(child))
+		}
+	case *ConditionalExpr:
+		n.Node, n.Question = "ConditionalExpr", encodeToken(expr.Question)
+		n.Condition, n.Then, n.Else = e.expr(expr.Condition), e.expr(expr.Then), e.expr(expr.Else)
+	case *GroupingExpr:
+		n.Node, n.Grouped = "GroupingExpr", e.expr(expr.Grouped)
+	case *IdentifierExpr:
+		n.Node, n.Token = "IdentifierExpr", encodeToken(expr.Token)
+	case *AssignExpr:
+		n.Node, n.Target, n.Value = "AssignExpr", encodeToken(expr.Target), e.expr(expr.Value)
+	case *CompoundAssignExpr:
+		n.Node, n.Target, n.Operator = "CompoundAssignExpr", encodeToken(expr.Target), encodeToken(expr.Operator)
+		n.Value = e.expr(expr.Value)
+	case *IncrementExpr:
+		n.Node, n.Target, n.Operator = "IncrementExpr", encodeToken(expr.Target), encodeToken(expr.Operator)
+		n.Prefix = expr.Prefix
+	case *OrExpr:
+		n.Node, n.Left, n.Right = "OrExpr", e.expr(expr.Left), e.expr(expr.Right)
+	case *AndExpr:
+		n.Node, n.Left, n.Right = "AndExpr", e.expr(expr.Left), e.expr(expr.Right)
+	case *CallExpr:
+		n.Node, n.Location, n.Callee = "CallExpr", encodeToken(expr.Location), e.expr(expr.Callee)
+		for _, argument := range expr.Arguments {
+			n.Arguments = append(n.Arguments, e.expr(argument))
+		}
+	case *GetExpr:
+		n.Node, n.Object, n.Name = "GetExpr", e.expr(expr.Object), encodeToken(expr.Name)
+	case *FunctionExpr:
+		n.Node, n.Keyword = "FunctionExpr", encodeToken(expr.Keyword)
+		e.setFunction(n, expr.FunctionBody)
+	case *SpawnExpr:
+		n.Node, n.Keyword, n.Call = "SpawnExpr", encodeToken(expr.Keyword), e.expr(expr.Call)
+	case *AwaitExpr:
+		n.Node, n.Keyword, n.Task = "AwaitExpr", encodeToken(expr.Keyword), e.expr(expr.Task)
+	default:
+		panic(assert.MissingCase(expr))
+	}
+	return n
+}
+
+// Decodes a program encoded by EncodeJSON() into a new Ast. Identifiers and string constants are interned in
+// strings, so the program can be executed by an interpreter using that table.
+func DecodeJSON(data []byte, strings *StringTable) (*Ast, error) {
+	var program jsonProgram
+	err := json.Unmarshal(data, &program)
+	if err != nil {
+		return nil, err
+	}
+	if program.Version != JSONVersion {
+		return nil, fmt.Errorf("unsupported version %d of the JSON format, expected %d", program.Version, JSONVersion)
+	}
+
+	d := jsonDecoder{ast: &Ast{}, strings: strings}
+	for _, node := range program.Body {
+		stmt, err := d.stmt(node)
+		if err != nil {
+			return nil, err
+		}
+		d.ast.Body = append(d.ast.Body, stmt)
+	}
+	return d.ast, nil
+}
+
+// Adds the decoded nodes to an Ast
+type jsonDecoder struct {
+	ast     *Ast
+	strings *StringTable
+}
+
+func (d *jsonDecoder) token(token *jsonToken, node string) (Token, error) {
+	if token == nil {
+		return Token{}, fmt.Errorf("%s is missing a token", node)
+	}
+
+	tokenType, ok := TokenTypeByName(token.Type)
+	if !ok {
+		return Token{}, fmt.Errorf("%s has a token of unknown type %q", node, token.Type)
+	}
+	decoded := Token{Type: tokenType, Lexeme: token.Lexeme, Line: token.Line}
+	if tokenType == IDENTIFIER {
+		decoded.Symbol = d.strings.Intern(token.Lexeme)
+	}
+
+	switch literal := token.Literal.(type) {
+	case nil:
+	case string:
+		decoded.Literal = NewStringValue(d.strings.Intern(literal))
+	case float64:
+		decoded.Literal = NewNumberValue(literal)
+	case bool:
+		decoded.Literal = NewBoolValue(literal)
+	default:
+		return Token{}, fmt.Errorf("%s has a token with an invalid literal %v", node, literal)
+	}
+	return decoded, nil
+}
+
+func (d *jsonDecoder) optionalToken(token *jsonToken, node string) (Token, error) {
+	if token == nil {
+		return Token{}, nil
+	}
+	return d.token(token, node)
+}
+
+func (d *jsonDecoder) annotation(annotation *jsonAnnotation, node string) (*TypeAnnotation, error) {
+	if annotation == nil {
+		return nil, nil
+	}
+	name, err := d.token(&annotation.Name, node)
+	if err != nil {
+		return nil, err
+	}
+	return &TypeAnnotation{Name: name}, nil
+}
+
+func (d *jsonDecoder) function(n *jsonNode) (FunctionBody, error) {
+	var function FunctionBody
+	for _, param := range n.Params {
+		token, err := d.token(&param, n.Node)
+		if err != nil {
+			return function, err
+		}
+		function.Params = append(function.Params, token)
+	}
+	for _, paramType := range n.ParamTypes {
+		annotation, err := d.annotation(paramType, n.Node)
+		if err != nil {
+			return function, err
+		}
+		function.ParamTypes = append(function.ParamTypes, annotation)
+	}
+	if len(function.ParamTypes) != len(function.Params) {
+		return function, fmt.Errorf("%s needs a type for each parameter", n.Node)
+	}
+
+	returnType, err := d.annotation(n.ReturnType, n.Node)
+	if err != nil {
+		return function, err
+	}
+	function.ReturnType = returnType
+	function.Body, err = d.stmtList(n.Body, n.Node)
+	return function, err
+}
+
+func (d *jsonDecoder) stmtList(data json.RawMessage, node string) ([]StmtID, error) {
+	var nodes []*jsonNode
+	if data != nil {
+		err := json.Unmarshal(data, &nodes)
+		if err != nil {
+			return nil, fmt.Errorf("the body of %s needs to be a list of statements: %w", node, err)
+		}
+	}
+
+	var stmts []StmtID
+	for _, n := range nodes {
+		stmt, err := d.stmt(n)
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+	}
+	return stmts, nil
+}
+
+func (d *jsonDecoder) body(data json.RawMessage, node string) (StmtID, error) {
+	var n *jsonNode
+	err := json.Unmarshal(data, &n)
+	if err != nil {
+		return 0, fmt.Errorf("the body of %s needs to be a statement: %w", node, err)
+	}
+	return d.stmt(n)
+}
+
+// Decodes a statement that may be absent, like the else branch of an if statement, to the ID 0
+func (d *jsonDecoder) optionalStmt(n *jsonNode) (StmtID, error) {
+	if n == nil {
+		return 0, nil
+	}
+	return d.stmt(n)
+}
+
+func (d *jsonDecoder) optionalExpr(n *jsonNode) (ExprID, error) {
+	if n == nil {
+		return 0, nil
+	}
+	return d.expr(n)
+}
+
+func (d *jsonDecoder) stmt(n *jsonNode) (StmtID, error) {
+	if n == nil {
+		return 0, fmt.Errorf("statement is missing")
+	}
+
+	// The first error stops the decoding, the remaining calls return early
+	var err error
+	stmt := func(n *jsonNode) StmtID {
+		if err != nil {
+			return 0
+		}
+		var s StmtID
+		s, err = d.optionalStmt(n)
+		return s
+	}
+	expr := func(n *jsonNode) ExprID {
+		if err != nil {
+			return 0
+		}
+		var e ExprID
+		e, err = d.optionalExpr(n)
+		return e
+	}
+	token := func(t *jsonToken) Token {
+		if err != nil {
+			return Token{}
+		}
+		var decoded Token
+		decoded, err = d.token(t, n.Node)
+		return decoded
+	}
+	required := func(present bool) {
+		if err == nil && !present {
+			err = fmt.Errorf("%s at line %d is incomplete", n.Node, n.Line)
+		}
+	}
+
+	ss := &d.ast.Statements
+	var result Stmt
+	switch n.Node {
+	case "ExprStmt":
+		e := expr(n.Expr)
+		required(e != 0)
+		result = ss.NewExpr(n.Line, e)
+	case "PrintStmt":
+		e := expr(n.Expr)
+		required(e != 0)
+		result = ss.NewPrint(n.Line, e)
+	case "VarDeclStmt":
+		identifier := token(n.Identifier)
+		var annotation *TypeAnnotation
+		if err == nil {
+			annotation, err = d.annotation(n.Type, n.Node)
+		}
+		result = ss.NewVarDecl(n.Line, identifier, annotation, expr(n.Value))
+	case "BlockStmt":
+		var body []StmtID
+		body, err = d.stmtList(n.Body, n.Node)
+		result = ss.NewBlock(n.Line, body)
+	case "IfStmt":
+		condition, then := expr(n.Condition), stmt(n.Then)
+		required(condition != 0)
+		required(then != 0)
+		result = ss.NewIf(n.Line, condition, then, stmt(n.Else))
+	case "WhileStmt":
+		condition, then := expr(n.Condition), stmt(n.Then)
+		required(condition != 0)
+		required(then != 0)
+		result = ss.NewWhile(n.Line, condition, then, expr(n.Increment))
+	case "SwitchStmt":
+		keyword, value := token(n.Keyword), expr(n.Value)
+		required(value != 0)
+		var cases []SwitchCase
+		for _, c := range n.Cases {
+			case_ := SwitchCase{Body: stmt(c.Body)}
+			required(case_.Body != 0)
+			for _, v := range c.Values {
+				case_.Values = append(case_.Values, expr(v))
+			}
+			cases = append(cases, case_)
+		}
+		result = ss.NewSwitch(n.Line, keyword, value, cases, stmt(n.Default))
+	case "BreakStmt":
+		result = ss.NewBreak(n.Line)
+	case "ContinueStmt":
+		result = ss.NewContinue(n.Line)
+	case "FunDeclStmt":
+		name := token(n.Name)
+		var function FunctionBody
+		if err == nil {
+			function, err = d.function(n)
+		}
+		result = ss.NewFunDecl(n.Line, name, function)
+	case "ReturnStmt":
+		result = ss.NewReturn(n.Line, token(n.Keyword), expr(n.Value))
+	case "ThrowStmt":
+		keyword, value := token(n.Keyword), expr(n.Value)
+		required(value != 0)
+		result = ss.NewThrow(n.Line, keyword, value)
+	case "TryStmt":
+		var body StmtID
+		body, err = d.body(n.Body, n.Node)
+		var catchName Token
+		if err == nil {
+			catchName, err = d.optionalToken(n.CatchName, n.Node)
+		}
+		catch, finally := stmt(n.Catch), stmt(n.Finally)
+		required(catch != 0 || finally != 0)
+		result = ss.NewTry(n.Line, body, catchName, catch, finally)
+	default:
+		return 0, fmt.Errorf("unknown statement %q", n.Node)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+	return result.ID(), nil
+}
+
+func (d *jsonDecoder) expr(n *jsonNode) (ExprID, error) {
+	if n == nil {
+		return 0, fmt.Errorf("expression is missing")
+	}
+
+	// The first error stops the decoding, the remaining calls return early
+	var err error
+	expr := func(n *jsonNode) ExprID {
+		if err != nil {
+			return 0
+		}
+		var e ExprID
+		e, err = d.expr(n)
+		return e
+	}
+	exprs := func(nodes []*jsonNode) []ExprID {
+		var decoded []ExprID
+		for _, n := range nodes {
+			decoded = append(decoded, expr(n))
+		}
+		return decoded
+	}
+	token := func(t *jsonToken) Token {
+		if err != nil {
+			return Token{}
+		}
+		var decoded Token
+		decoded, err = d.token(t, n.Node)
+		return decoded
+	}
+
+	es := &d.ast.Expressions
+	var result Expr
+	switch n.Node {
+	case "LiteralExpr":
+		result = es.NewLiteralExpr(token(n.Token))
+	case "UnaryExpr":
+		result = es.NewUnaryExpr(token(n.Operator), expr(n.Operand))
+	case "BinaryExpr":
+		result = es.NewBinaryExpr(token(n.Operator), expr(n.Left), expr(n.Right))
+	case "SequenceExpr":
+		result = es.NewSequenceExpr(exprs(n.Exprs))
+	case "ConditionalExpr":
+		result = es.NewConditionalExpr(token(n.Question), expr(n.Condition), expr(n.Then), expr(n.Else))
+	case "GroupingExpr":
+		result = es.NewGroupingExpr(expr(n.Grouped))
+	case "IdentifierExpr":
+		result = es.NewIdentifierExpr(token(n.Token))
+	case "AssignExpr":
+		result = es.NewAssignExpr(token(n.Target), expr(n.Value))
+	case "CompoundAssignExpr":
+		result = es.NewCompoundAssignExpr(token(n.Target), token(n.Operator), expr(n.Value))
+	case "IncrementExpr":
+		result = es.NewIncrementExpr(token(n.Target), token(n.Operator), n.Prefix)
+	case "OrExpr":
+		result = es.NewOrExpr(expr(n.Left), expr(n.Right))
+	case "AndExpr":
+		result = es.NewAndExpr(expr(n.Left), expr(n.Right))
+	case "CallExpr":
+		// Like the parser, calls without arguments have an empty rather than a nil list
+		arguments := append([]ExprID{}, exprs(n.Arguments)...)
+		result = es.NewCallExpr(token(n.Location), expr(n.Callee), arguments)
+	case "GetExpr":
+		result = es.NewGetExpr(expr(n.Object), token(n.Name))
+	case "FunctionExpr":
+		keyword := token(n.Keyword)
+		var function FunctionBody
+		if err == nil {
+			function, err = d.function(n)
+		}
+		result = es.NewFunctionExpr(keyword, function)
+	case "SpawnExpr":
+		keyword, call := token(n.Keyword), expr(n.Call)
+		if _, ok := d.ast.Expr(call).(*CallExpr); err == nil && !ok {
+			err = fmt.Errorf("SpawnExpr needs to spawn a CallExpr")
+		}
+		result = es.NewSpawnExpr(keyword, call)
+	case "AwaitExpr":
+		result = es.NewAwaitExpr(token(n.Keyword), expr(n.Task))
+	default:
+		return 0, fmt.Errorf("unknown expression %q", n.Node)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+	return result.ID(), nil
+}
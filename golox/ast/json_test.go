@@ -0,0 +1,158 @@
+package ast_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"toterich/golox/ast"
+	"toterich/golox/parse"
+)
+
+func parseProgram(source string) (*ast.Ast, []error) {
+	scanner := parse.NewScanner(ast.NewStringTable())
+	tokens, errs := scanner.ScanTokens(source)
+	if errs != nil {
+		return nil, errs
+	}
+	var parser parse.Parser
+	return parser.Parse(tokens)
+}
+
+var (
+	exprIDType = reflect.TypeFor[ast.ExprID]()
+	stmtIDType = reflect.TypeFor[ast.StmtID]()
+)
+
+// Compares the nodes of two trees like reflect.DeepEqual, except that child IDs are compared by the nodes they
+// resolve to in their tree, and the nodes' own IDs are ignored
+type treeComparison struct {
+	a, b *ast.Ast
+}
+
+func (c treeComparison) equal(a, b reflect.Value) bool {
+	if !a.IsValid() || !b.IsValid() {
+		return a.IsValid() == b.IsValid()
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a.Type() {
+	case exprIDType:
+		return c.equal(reflect.ValueOf(c.a.Expr(ast.ExprID(a.Uint()))), reflect.ValueOf(c.b.Expr(ast.ExprID(b.Uint()))))
+	case stmtIDType:
+		return c.equal(reflect.ValueOf(c.a.Stmt(ast.StmtID(a.Uint()))), reflect.ValueOf(c.b.Stmt(ast.StmtID(b.Uint()))))
+	}
+
+	switch a.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return c.equal(a.Elem(), b.Elem())
+	case reflect.Slice:
+		if a.IsNil() != b.IsNil() || a.Len() != b.Len() {
+			return false
+		}
+		for idx := range a.Len() {
+			if !c.equal(a.Index(idx), b.Index(idx)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		for idx := range a.NumField() {
+			// The embedded struct holding the node's own ID
+			if field := a.Type().Field(idx); field.Anonymous && !field.IsExported() {
+				continue
+			}
+			if !c.equal(a.Field(idx), b.Field(idx)) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a.Equal(b)
+	}
+}
+
+// Programs decoded from JSON need to be identical to the ones the parser created, except for the IDs of their
+// nodes, which depend on the order in which they were allocated
+func TestJSONRoundTrip(t *testing.T) {
+	files, err := filepath.Glob("../../lox_spec/samples/*.lox")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tree, errs := parseProgram(string(data))
+			if errs != nil {
+				// Samples of features that haven't been implemented yet
+				t.Skip(errs)
+			}
+
+			encoded, err := ast.EncodeJSON(tree)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := ast.DecodeJSON(encoded, ast.NewStringTable())
+			if err != nil {
+				t.Fatal(err)
+			}
+			comparison := treeComparison{a: decoded, b: tree}
+			if !comparison.equal(reflect.ValueOf(decoded.Body), reflect.ValueOf(tree.Body)) {
+				t.Errorf("decoded program differs from the parsed one:\n%s", ast.FormatSExpr(decoded))
+			}
+
+			reencoded, err := ast.EncodeJSON(decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(reencoded) != string(encoded) {
+				t.Errorf("encoding the decoded program gives different JSON:\n%s", reencoded)
+			}
+		})
+	}
+}
+
+func TestDecodeJSONErrors(t *testing.T) {
+	tests := map[string]string{
+		`{"version": 2, "body": []}`:                                                                    "unsupported version 2 of the JSON format, expected 1",
+		`{"version": 1, "body": [{"node": "LoopStmt", "line": 1}]}`:                                     `unknown statement "LoopStmt"`,
+		`{"version": 1, "body": [{"node": "PrintStmt", "line": 3}]}`:                                    "PrintStmt at line 3 is incomplete",
+		`{"version": 1, "body": [{"node": "ExprStmt", "line": 1, "expr": {"node": "IdentifierExpr"}}]}`: "IdentifierExpr is missing a token",
+		`{"version": 1, "body": [{"node": "ExprStmt", "line": 1, "expr": {"node": "LiteralExpr",
+			"token": {"type": "NUMBR", "lexeme": "1", "line": 1, "literal": 1}}}]}`: `LiteralExpr has a token of unknown type "NUMBR"`,
+	}
+
+	for data, expected := range tests {
+		_, err := ast.DecodeJSON([]byte(data), ast.NewStringTable())
+		if err == nil || err.Error() != expected {
+			t.Errorf("%s: expected error %q, got %v", data, expected, err)
+		}
+	}
+}
+
+// For loops are desugared into a while loop in a block with the initializer
+func TestFormatSExpr(t *testing.T) {
+	tree, errs := parseProgram("for (var i = 0; ; i++) {\n  print i;\n}\n")
+	if errs != nil {
+		t.Fatal(errs)
+	}
+
+	expected := `(BlockStmt @1
+  (VarDeclStmt @1 i@1 (LiteralExpr 0@1))
+  (WhileStmt @1 (LiteralExpr TRUE@1) :increment (IncrementExpr i@1 ++@1)
+    (BlockStmt @1
+      (PrintStmt @2 (IdentifierExpr i@2)))))
+`
+	if actual := ast.FormatSExpr(tree); actual != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, actual)
+	}
+}
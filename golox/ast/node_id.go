@@ -0,0 +1,80 @@
+package ast
+
+// Identifies an expression within its Ast. Nodes refer to their child expressions by ID, which the Ast resolves
+// back to the expressions, see Ast.Expr(). IDs are assigned by the ExprStore in the order the expressions are
+// allocated, starting at 1, so they can also be used to index side tables. The ID 0 stands for an absent
+// expression, like the initializer of a variable declaration without one.
+type ExprID uint32
+
+// Identifies a statement within its Ast, like ExprID does for expressions
+type StmtID uint32
+
+// Embedded in every expression to record its ID
+type exprNode struct {
+	id ExprID
+}
+
+func (n exprNode) ID() ExprID {
+	return n.id
+}
+
+// Embedded in every statement to record its ID
+type stmtNode struct {
+	id StmtID
+}
+
+func (n stmtNode) ID() StmtID {
+	return n.id
+}
+
+// Associates values with the nodes of an Ast by their IDs without adding fields to the nodes, like the types
+// the type checker infers for expressions, or the execution counts coverage records for statements and
+// branches. Values are stored in a slice indexed by ID, so a lookup costs no more than a map lookup on the
+// node would. The zero SideTable is empty and ready to use.
+//
+// IDs are only unique within a single Ast, so a SideTable must not be used for the nodes of more than one.
+type SideTable[ID ExprID | StmtID, T any] struct {
+	values []T
+	set    []bool
+}
+
+// Associates value with the node with the given ID, replacing the value it had before
+func (t *SideTable[ID, T]) Set(id ID, value T) {
+	if id == 0 {
+		panic("the ID 0 doesn't identify a node")
+	}
+	if int(id) >= len(t.values) {
+		t.values = append(t.values, make([]T, int(id)+1-len(t.values))...)
+		t.set = append(t.set, make([]bool, int(id)+1-len(t.set))...)
+	}
+	t.values[id] = value
+	t.set[id] = true
+}
+
+// Returns the value associated with the node with the given ID, and whether there is one
+func (t *SideTable[ID, T]) Get(id ID) (T, bool) {
+	if int(id) >= len(t.values) || !t.set[id] {
+		var none T
+		return none, false
+	}
+	return t.values[id], true
+}
+
+// Removes the value associated with the node with the given ID, if any
+func (t *SideTable[ID, T]) Delete(id ID) {
+	if int(id) < len(t.values) {
+		var none T
+		t.values[id] = none
+		t.set[id] = false
+	}
+}
+
+// Selects the arena of a store that a node is allocated in
+type nodeKind uint8
+
+// Locates a node in the arena of its kind. The stores keep one per node, so that IDs can be assigned densely
+// across all kinds of nodes.
+type nodeRef struct {
+	kind  nodeKind
+	index uint32
+}
@@ -0,0 +1,102 @@
+package ast_test
+
+import (
+	"testing"
+	"toterich/golox/ast"
+	"toterich/golox/parse"
+)
+
+// Nodes must stay where the store allocated them, so that changes made through the pointer returned by the
+// store are seen by everyone who has the node
+func TestStorePointersStayValid(t *testing.T) {
+	var store ast.ExprStore
+	first := store.NewLiteralExpr(ast.Token{Type: ast.NUMBER, Lexeme: "0"})
+	for idx := range 10000 {
+		// Interleave kinds of expressions, which are allocated in arenas of their own
+		store.NewLiteralExpr(ast.Token{Type: ast.NUMBER, Line: idx})
+		store.NewIdentifierExpr(ast.Token{Type: ast.IDENTIFIER, Line: idx})
+	}
+
+	first.Token.Lexeme = "1"
+	if resolved := store.Get(first.ID()).(*ast.LiteralExpr); resolved != first || resolved.Token.Lexeme != "1" {
+		t.Errorf("expression %d resolves to %v instead of %v", first.ID(), resolved, first)
+	}
+	if store.Len() != 20001 {
+		t.Errorf("expected 20001 expressions, got %d", store.Len())
+	}
+	for idx := range 10000 {
+		literal, ok := store.Get(ast.ExprID(2*idx + 2)).(*ast.LiteralExpr)
+		if !ok || literal.Token.Line != idx {
+			t.Fatalf("expression %d resolves to %v instead of the literal at line %d", 2*idx+2, literal, idx)
+		}
+		identifier, ok := store.Get(ast.ExprID(2*idx + 3)).(*ast.IdentifierExpr)
+		if !ok || identifier.Token.Line != idx {
+			t.Fatalf("expression %d resolves to %v instead of the identifier at line %d", 2*idx+3, identifier, idx)
+		}
+	}
+}
+
+// Every node of a parsed program has an ID of its own, which its Ast resolves back to the node. The stores may
+// hold more nodes than the program, as the parser discards some, e.g. the block of a function's body.
+func TestParsedNodeIDs(t *testing.T) {
+	scanner := parse.NewScanner(ast.NewStringTable())
+	tokens, errs := scanner.ScanTokens(everyNode)
+	if errs != nil {
+		t.Fatal(errs)
+	}
+	var parser parse.Parser
+	tree, errs := parser.Parse(tokens)
+	if errs != nil {
+		t.Fatal(errs)
+	}
+
+	stmtIDs, exprIDs := map[ast.StmtID]bool{}, map[ast.ExprID]bool{}
+	for _, stmt := range tree.Body {
+		ast.Inspect(tree, tree.Stmt(stmt), func(node ast.Node) bool {
+			switch node := node.(type) {
+			case ast.Stmt:
+				if stmtIDs[node.ID()] || tree.Statements.Get(node.ID()) != node {
+					t.Errorf("statement %d doesn't identify %T at line %d", node.ID(), node, node.StartLine())
+				}
+				stmtIDs[node.ID()] = true
+			case ast.Expr:
+				if exprIDs[node.ID()] || tree.Expressions.Get(node.ID()) != node {
+					t.Errorf("expression %d doesn't identify %T", node.ID(), node)
+				}
+				exprIDs[node.ID()] = true
+			}
+			return true
+		})
+	}
+}
+
+func TestSideTable(t *testing.T) {
+	var store ast.ExprStore
+	a := store.NewLiteralExpr(ast.Token{Type: ast.NIL})
+	b := store.NewLiteralExpr(ast.Token{Type: ast.NIL})
+	c := store.NewLiteralExpr(ast.Token{Type: ast.NIL})
+
+	var types ast.SideTable[ast.ExprID, string]
+	types.Set(c.ID(), "Nil")
+	types.Set(a.ID(), "Any")
+	types.Set(a.ID(), "Nil")
+
+	for _, test := range []struct {
+		expr     ast.Expr
+		expected string
+		ok       bool
+	}{{a, "Nil", true}, {b, "", false}, {c, "Nil", true}} {
+		if value, ok := types.Get(test.expr.ID()); value != test.expected || ok != test.ok {
+			t.Errorf("expected (%q, %v) for expression %d, got (%q, %v)", test.expected, test.ok, test.expr.ID(), value, ok)
+		}
+	}
+
+	types.Delete(c.ID())
+	types.Delete(ast.ExprID(100))
+	if _, ok := types.Get(c.ID()); ok {
+		t.Errorf("expression %d still has a value after it has been deleted", c.ID())
+	}
+	if _, ok := types.Get(ast.ExprID(100)); ok {
+		t.Errorf("expression 100 has a value without one being set")
+	}
+}
@@ -0,0 +1,254 @@
+package ast
+
+import (
+	"strconv"
+	"strings"
+	"toterich/golox/util/assert"
+)
+
+// Formats a program as S-expressions, one line per statement, with nested statements indented below the
+// statement they are part of. Each node is written as (Type children...), statements with @line after their
+// type. Tokens are written as lexeme@line, or as their type if they have no lexeme because the parser created
+// them, e.g. the TRUE condition of a for loop without condition. Children that are optional are labeled, e.g.
+// :else.
+func FormatSExpr(tree *Ast) string {
+	p := sexprPrinter{tree: tree}
+	for _, stmt := range tree.Body {
+		p.stmt(stmt)
+		p.b.WriteString("\n")
+	}
+	return p.b.String()
+}
+
+type sexprPrinter struct {
+	tree   *Ast
+	b      strings.Builder
+	indent int
+}
+
+func (p *sexprPrinter) write(parts ...string) {
+	for _, part := range parts {
+		p.b.WriteString(part)
+	}
+}
+
+func (p *sexprPrinter) token(token Token) {
+	p.write(" ")
+	if token.Lexeme == "" {
+		p.write(token.Type.String())
+	} else {
+		p.write(token.Lexeme)
+	}
+	p.write("@", strconv.Itoa(token.Line))
+}
+
+func (p *sexprPrinter) annotation(label string, annotation *TypeAnnotation) {
+	if annotation != nil {
+		p.write(" ", label)
+		p.token(annotation.Name)
+	}
+}
+
+// Writes a statement nested in the current one on a line of its own
+func (p *sexprPrinter) child(label string, stmt StmtID) {
+	if stmt == 0 {
+		return
+	}
+	p.indent += 1
+	p.write("\n", strings.Repeat("  ", p.indent))
+	if label != "" {
+		p.write(label, " ")
+	}
+	p.stmt(stmt)
+	p.indent -= 1
+}
+
+func (p *sexprPrinter) children(stmts []StmtID) {
+	for _, stmt := range stmts {
+		p.child("", stmt)
+	}
+}
+
+func (p *sexprPrinter) function(function FunctionBody) {
+	p.write(" (params")
+	for idx, param := range function.Params {
+		p.token(param)
+		if idx < len(function.ParamTypes) {
+			p.annotation(":type", function.ParamTypes[idx])
+		}
+	}
+	p.write(")")
+	p.annotation(":returns", function.ReturnType)
+	p.children(function.Body)
+}
+
+func (p *sexprPrinter) stmt(id StmtID) {
+	switch stmt := p.tree.Stmt(id).(type) {
+	case *ExprStmt:
+		p.open("ExprStmt", stmt.Line)
+		p.expr(stmt.Expr)
+	case *PrintStmt:
+		p.open("PrintStmt", stmt.Line)
+		p.expr(stmt.Expr)
+	case *VarDeclStmt:
+		p.open("VarDeclStmt", stmt.Line)
+		p.token(stmt.Identifier)
+		p.annotation(":type", stmt.Type)
+		if stmt.Value != 0 {
+			p.expr(stmt.Value)
+		}
+	case *BlockStmt:
+		p.open("BlockStmt", stmt.Line)
+		p.children(stmt.Body)
+	case *IfStmt:
+		p.open("IfStmt", stmt.Line)
+		p.expr(stmt.Condition)
+		p.child("", stmt.Then)
+		p.child(":else", stmt.Else)
+	case *WhileStmt:
+		p.open("WhileStmt", stmt.Line)
+		p.expr(stmt.Condition)
+		if stmt.Increment != 0 {
+			p.write(" :increment")
+			p.expr(stmt.Increment)
+		}
+		p.child("", stmt.Then)
+	case *SwitchStmt:
+		p.open("SwitchStmt", stmt.Line)
+		p.token(stmt.Keyword)
+		p.expr(stmt.Value)
+		p.indent += 1
+		for _, case_ := range stmt.Cases {
+			p.write("\n", strings.Repeat("  ", p.indent), "(case")
+			for _, value := range case_.Values {
+				p.expr(value)
+			}
+			p.child("", case_.Body)
+			p.write(")")
+		}
+		p.indent -= 1
+		p.child(":default", stmt.Default)
+	case *BreakStmt:
+		p.open("BreakStmt", stmt.Line)
+	case *ContinueStmt:
+		p.open("ContinueStmt", stmt.Line)
+	case *FunDeclStmt:
+		p.open("FunDeclStmt", stmt.Line)
+		p.token(stmt.Name)
+		p.function(stmt.FunctionBody)
+	case *ReturnStmt:
+		p.open("ReturnStmt", stmt.Line)
+		p.token(stmt.Keyword)
+		if stmt.Value != 0 {
+			p.expr(stmt.Value)
+		}
+	case *ThrowStmt:
+		p.open("ThrowStmt", stmt.Line)
+		p.token(stmt.Keyword)
+		p.expr(stmt.Value)
+	case *TryStmt:
+		p.open("TryStmt", stmt.Line)
+		p.child("", stmt.Body)
+		if stmt.Catch != 0 {
+			p.indent += 1
+			p.write("\n", strings.Repeat("  ", p.indent), ":catch")
+			p.token(stmt.CatchName)
+			p.indent -= 1
+			p.child("", stmt.Catch)
+		}
+		p.child(":finally", stmt.Finally)
+	default:
+		panic(assert.MissingCase(stmt))
+	}
+	p.write(")")
+}
+
+// Writes the start of a statement
+func (p *sexprPrinter) open(name string, line int) {
+	p.write("(", name, " @", strconv.Itoa(line))
+}
+
+func (p *sexprPrinter) expr(id ExprID) {
+	p.write(" (")
+	switch expr := p.tree.Expr(id).(type) {
+	case *LiteralExpr:
+		p.write("LiteralExpr")
+		p.token(expr.Token)
+	case *UnaryExpr:
+		p.write("UnaryExpr")
+		p.token(expr.Operator)
+		p.expr(expr.Operand)
+	case *BinaryExpr:
+		p.write("BinaryExpr")
+		p.token(expr.Operator)
+		p.expr(expr.Left)
+		p.expr(expr.Right)
+	case *SequenceExpr:
+		p.write("SequenceExpr")
+		for _, e := range expr.Exprs {
+			p.expr(e)
+		}
+	case *ConditionalExpr:
+		p.write("ConditionalExpr")
+		p.token(expr.Question)
+		p.expr(expr.Condition)
+		p.expr(expr.Then)
+		p.expr(expr.Else)
+	case *GroupingExpr:
+		p.write("GroupingExpr")
+		p.expr(expr.Grouped)
+	case *IdentifierExpr:
+		p.write("IdentifierExpr")
+		p.token(expr.Token)
+	case *AssignExpr:
+		p.write("AssignExpr")
+		p.token(expr.Target)
+		p.expr(expr.Value)
+	case *CompoundAssignExpr:
+		p.write("CompoundAssignExpr")
+		p.token(expr.Target)
+		p.token(expr.Operator)
+		p.expr(expr.Value)
+	case *IncrementExpr:
+		p.write("IncrementExpr")
+		p.token(expr.Target)
+		p.token(expr.Operator)
+		if expr.Prefix {
+			p.write(" :prefix")
+		}
+	case *OrExpr:
+		p.write("OrExpr")
+		p.expr(expr.Left)
+		p.expr(expr.Right)
+	case *AndExpr:
+		p.write("AndExpr")
+		p.expr(expr.Left)
+		p.expr(expr.Right)
+	case *CallExpr:
+		p.write("CallExpr")
+		p.token(expr.Location)
+		p.expr(expr.Callee)
+		for _, argument := range expr.Arguments {
+			p.expr(argument)
+		}
+	case *GetExpr:
+		p.write("GetExpr")
+		p.expr(expr.Object)
+		p.token(expr.Name)
+	case *FunctionExpr:
+		p.write("FunctionExpr")
+		p.token(expr.Keyword)
+		p.function(expr.FunctionBody)
+	case *SpawnExpr:
+		p.write("SpawnExpr")
+		p.token(expr.Keyword)
+		p.expr(expr.Call)
+	case *AwaitExpr:
+		p.write("AwaitExpr")
+		p.token(expr.Keyword)
+		p.expr(expr.Task)
+	default:
+		panic(assert.MissingCase(expr))
+	}
+	p.write(")")
+}
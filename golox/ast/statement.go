@@ -1,117 +1,324 @@
 package ast
 
+// Tag interface for Statement types. Statements refer to their children by StmtID and ExprID, see Ast.Stmt().
 type Stmt interface {
 	isStmt()
+	// Returns the line the statement starts at
+	StartLine() int
+	// Returns the ID the StmtStore assigned to the statement
+	ID() StmtID
+}
+
+// Embedded in every statement to record the line it starts at
+type Position struct {
+	Line int
+}
+
+func (p Position) StartLine() int {
+	return p.Line
 }
 
 type ExprStmt struct {
-	Expr Expr
+	stmtNode
+	Position
+	Expr ExprID
 }
 
 func (s ExprStmt) isStmt() {}
 
 type PrintStmt struct {
-	Expr Expr
+	stmtNode
+	Position
+	Expr ExprID
 }
 
 func (s PrintStmt) isStmt() {}
 
+// An optional static type annotation, e.g. the Number in var x: Number. Annotations are only used by the type
+// checker, the interpreter ignores them.
+type TypeAnnotation struct {
+	Name Token
+}
+
 type VarDeclStmt struct {
+	stmtNode
+	Position
 	Identifier Token
-	Value      Expr
+	Type       *TypeAnnotation // nil without annotation
+	Value      ExprID          // 0 without initializer
 }
 
 func (s VarDeclStmt) isStmt() {}
 
 type BlockStmt struct {
-	Body []Stmt
+	stmtNode
+	Position
+	Body []StmtID
 }
 
 func (s BlockStmt) isStmt() {}
 
 type IfStmt struct {
-	Condition Expr
-	Then      Stmt
-	Else      Stmt
+	stmtNode
+	Position
+	Condition ExprID
+	Then      StmtID
+	Else      StmtID
 }
 
 func (s IfStmt) isStmt() {}
 
+// A loop. For loops are desugared into while loops, with their increment kept separately so that it also
+// runs after a continue statement. For plain while loops, Increment is 0.
 type WhileStmt struct {
-	Condition Expr
-	Then      Stmt
+	stmtNode
+	Position
+	Condition ExprID
+	Then      StmtID
+	Increment ExprID
 }
 
 func (s WhileStmt) isStmt() {}
 
+// A single case of a switch statement, which is executed if the switch value equals any of the case's Values
+type SwitchCase struct {
+	Values []ExprID // Literal expressions
+	Body   StmtID
+}
+
+// A switch statement executes at most one of its cases, there is no fallthrough. The Default case is 0 if
+// it has been omitted.
+type SwitchStmt struct {
+	stmtNode
+	Position
+	Keyword Token
+	Value   ExprID
+	Cases   []SwitchCase
+	Default StmtID
+}
+
+func (s SwitchStmt) isStmt() {}
+
 type BreakStmt struct {
+	stmtNode
+	Position
 }
 
 func (s BreakStmt) isStmt() {}
 
+type ContinueStmt struct {
+	stmtNode
+	Position
+}
+
+func (s ContinueStmt) isStmt() {}
+
+// Parameters and body shared by function declarations and anonymous functions
+type FunctionBody struct {
+	Params     []Token
+	ParamTypes []*TypeAnnotation // Parallel to Params, nil for parameters without annotation
+	ReturnType *TypeAnnotation   // nil without annotation
+	Body       []StmtID
+}
+
 type FunDeclStmt struct {
-	Name   Token
-	Params []Token
-	Body   []Stmt
+	stmtNode
+	Position
+	Name Token
+	FunctionBody
 }
 
 func (s FunDeclStmt) isStmt() {}
 
+type ReturnStmt struct {
+	stmtNode
+	Position
+	Keyword Token
+	Value   ExprID // 0 without a value
+}
+
+func (s ReturnStmt) isStmt() {}
+
+type ThrowStmt struct {
+	stmtNode
+	Position
+	Keyword Token
+	Value   ExprID
+}
+
+func (s ThrowStmt) isStmt() {}
+
+// A try statement always has a Body and at least one of Catch and Finally, the others are 0.
+type TryStmt struct {
+	stmtNode
+	Position
+	Body      StmtID
+	CatchName Token // Identifier the caught value is bound to inside the Catch block
+	Catch     StmtID
+	Finally   StmtID
+}
+
+func (s TryStmt) isStmt() {}
+
+// Allocates the statements of an Ast, see ExprStore
 type StmtStore struct {
-	Expr    []ExprStmt
-	Print   []PrintStmt
-	VarDecl []VarDeclStmt
-	Block   []BlockStmt
-	If      []IfStmt
-	While   []WhileStmt
-	Break   []BreakStmt
-	FunDecl []FunDeclStmt
+	expr      arena[ExprStmt]
+	print     arena[PrintStmt]
+	varDecl   arena[VarDeclStmt]
+	block     arena[BlockStmt]
+	if_       arena[IfStmt]
+	while     arena[WhileStmt]
+	switch_   arena[SwitchStmt]
+	break_    arena[BreakStmt]
+	continue_ arena[ContinueStmt]
+	funDecl   arena[FunDeclStmt]
+	return_   arena[ReturnStmt]
+	throw     arena[ThrowStmt]
+	try       arena[TryStmt]
+
+	refs []nodeRef // Locates each statement in its arena, indexed by ID - 1
+}
+
+// Kinds of statements, which select the arena a nodeRef points into
+const (
+	exprStmt nodeKind = iota + 1
+	printStmt
+	varDeclStmt
+	blockStmt
+	ifStmt
+	whileStmt
+	switchStmt
+	breakStmt
+	continueStmt
+	funDeclStmt
+	returnStmt
+	throwStmt
+	tryStmt
+)
+
+// Registers a newly allocated statement and returns its ID
+func (ss *StmtStore) add(kind nodeKind, index int) StmtID {
+	ss.refs = append(ss.refs, nodeRef{kind: kind, index: uint32(index)})
+	return StmtID(len(ss.refs))
+}
+
+// Returns the statement with the given ID, which must have been assigned by this store, or nil for the ID 0
+func (ss *StmtStore) Get(id StmtID) Stmt {
+	if id == 0 {
+		return nil
+	}
+	ref := ss.refs[id-1]
+	index := int(ref.index)
+	switch ref.kind {
+	case exprStmt:
+		return ss.expr.get(index)
+	case printStmt:
+		return ss.print.get(index)
+	case varDeclStmt:
+		return ss.varDecl.get(index)
+	case blockStmt:
+		return ss.block.get(index)
+	case ifStmt:
+		return ss.if_.get(index)
+	case whileStmt:
+		return ss.while.get(index)
+	case switchStmt:
+		return ss.switch_.get(index)
+	case breakStmt:
+		return ss.break_.get(index)
+	case continueStmt:
+		return ss.continue_.get(index)
+	case funDeclStmt:
+		return ss.funDecl.get(index)
+	case returnStmt:
+		return ss.return_.get(index)
+	case throwStmt:
+		return ss.throw.get(index)
+	case tryStmt:
+		return ss.try.get(index)
+	}
+	panic("unknown statement kind")
+}
+
+// Returns the number of statements allocated so far, which is also the largest ID assigned
+func (ss *StmtStore) Len() int {
+	return len(ss.refs)
+}
+
+func (ss *StmtStore) NewExpr(line int, expr ExprID) *ExprStmt {
+	stmt, index := ss.expr.alloc(ExprStmt{Position: Position{Line: line}, Expr: expr})
+	stmt.id = ss.add(exprStmt, index)
+	return stmt
+}
+
+func (ss *StmtStore) NewPrint(line int, expr ExprID) *PrintStmt {
+	stmt, index := ss.print.alloc(PrintStmt{Position: Position{Line: line}, Expr: expr})
+	stmt.id = ss.add(printStmt, index)
+	return stmt
+}
+
+func (ss *StmtStore) NewVarDecl(line int, identifier Token, type_ *TypeAnnotation, value ExprID) *VarDeclStmt {
+	stmt, index := ss.varDecl.alloc(VarDeclStmt{Position: Position{Line: line}, Identifier: identifier, Type: type_, Value: value})
+	stmt.id = ss.add(varDeclStmt, index)
+	return stmt
+}
+
+func (ss *StmtStore) NewBlock(line int, children []StmtID) *BlockStmt {
+	stmt, index := ss.block.alloc(BlockStmt{Position: Position{Line: line}, Body: children})
+	stmt.id = ss.add(blockStmt, index)
+	return stmt
+}
+
+func (ss *StmtStore) NewIf(line int, condition ExprID, then StmtID, else_ StmtID) *IfStmt {
+	stmt, index := ss.if_.alloc(IfStmt{Position: Position{Line: line}, Condition: condition, Then: then, Else: else_})
+	stmt.id = ss.add(ifStmt, index)
+	return stmt
 }
 
-func (ss *StmtStore) NewExpr(expr Expr) *ExprStmt {
-	idx := len(ss.Expr)
-	ss.Expr = append(ss.Expr, ExprStmt{Expr: expr})
-	return &ss.Expr[idx]
+func (ss *StmtStore) NewWhile(line int, condition ExprID, then StmtID, increment ExprID) *WhileStmt {
+	stmt, index := ss.while.alloc(WhileStmt{Position: Position{Line: line}, Condition: condition, Then: then, Increment: increment})
+	stmt.id = ss.add(whileStmt, index)
+	return stmt
 }
 
-func (ss *StmtStore) NewPrint(expr Expr) *PrintStmt {
-	idx := len(ss.Print)
-	ss.Print = append(ss.Print, PrintStmt{Expr: expr})
-	return &ss.Print[idx]
+func (ss *StmtStore) NewSwitch(line int, keyword Token, value ExprID, cases []SwitchCase, default_ StmtID) *SwitchStmt {
+	stmt, index := ss.switch_.alloc(SwitchStmt{Position: Position{Line: line}, Keyword: keyword, Value: value, Cases: cases, Default: default_})
+	stmt.id = ss.add(switchStmt, index)
+	return stmt
 }
 
-func (ss *StmtStore) NewVarDecl(identifier Token, value Expr) *VarDeclStmt {
-	idx := len(ss.VarDecl)
-	ss.VarDecl = append(ss.VarDecl, VarDeclStmt{Identifier: identifier, Value: value})
-	return &ss.VarDecl[idx]
+func (ss *StmtStore) NewBreak(line int) *BreakStmt {
+	stmt, index := ss.break_.alloc(BreakStmt{Position: Position{Line: line}})
+	stmt.id = ss.add(breakStmt, index)
+	return stmt
 }
 
-func (ss *StmtStore) NewBlock(children []Stmt) *BlockStmt {
-	idx := len(ss.Block)
-	ss.Block = append(ss.Block, BlockStmt{Body: children})
-	return &ss.Block[idx]
+func (ss *StmtStore) NewContinue(line int) *ContinueStmt {
+	stmt, index := ss.continue_.alloc(ContinueStmt{Position: Position{Line: line}})
+	stmt.id = ss.add(continueStmt, index)
+	return stmt
 }
 
-func (ss *StmtStore) NewIf(condition Expr, then Stmt, else_ Stmt) *IfStmt {
-	idx := len(ss.If)
-	ss.If = append(ss.If, IfStmt{Condition: condition, Then: then, Else: else_})
-	return &ss.If[idx]
+func (ss *StmtStore) NewFunDecl(line int, name Token, function FunctionBody) *FunDeclStmt {
+	stmt, index := ss.funDecl.alloc(FunDeclStmt{Position: Position{Line: line}, Name: name, FunctionBody: function})
+	stmt.id = ss.add(funDeclStmt, index)
+	return stmt
 }
 
-func (ss *StmtStore) NewWhile(condition Expr, then Stmt) *WhileStmt {
-	idx := len(ss.While)
-	ss.While = append(ss.While, WhileStmt{Condition: condition, Then: then})
-	return &ss.While[idx]
+func (ss *StmtStore) NewReturn(line int, keyword Token, value ExprID) *ReturnStmt {
+	stmt, index := ss.return_.alloc(ReturnStmt{Position: Position{Line: line}, Keyword: keyword, Value: value})
+	stmt.id = ss.add(returnStmt, index)
+	return stmt
 }
 
-func (ss *StmtStore) NewBreak() *BreakStmt {
-	idx := len(ss.Break)
-	ss.Break = append(ss.Break, BreakStmt{})
-	return &ss.Break[idx]
+func (ss *StmtStore) NewThrow(line int, keyword Token, value ExprID) *ThrowStmt {
+	stmt, index := ss.throw.alloc(ThrowStmt{Position: Position{Line: line}, Keyword: keyword, Value: value})
+	stmt.id = ss.add(throwStmt, index)
+	return stmt
 }
 
-func (ss *StmtStore) NewFunDecl(name Token, params []Token, children []Stmt) *FunDeclStmt {
-	idx := len(ss.FunDecl)
-	ss.FunDecl = append(ss.FunDecl, FunDeclStmt{Name: name, Params: params, Body: children})
-	return &ss.FunDecl[idx]
+func (ss *StmtStore) NewTry(line int, body StmtID, catchName Token, catch StmtID, finally StmtID) *TryStmt {
+	stmt, index := ss.try.alloc(TryStmt{Position: Position{Line: line}, Body: body, CatchName: catchName, Catch: catch, Finally: finally})
+	stmt.id = ss.add(tryStmt, index)
+	return stmt
 }
@@ -0,0 +1,82 @@
+package ast
+
+import "sync"
+
+// A handle to a string interned in a StringTable. Two Symbols of the same table are equal if and only if their
+// strings are equal, so Symbols can be compared and used as map keys as cheaply as pointers.
+// The zero Symbol doesn't belong to any table.
+type Symbol struct {
+	str *string
+}
+
+func (s Symbol) String() string {
+	if s.str == nil {
+		return ""
+	}
+	return *s.str
+}
+
+// Interns strings, so that each distinct string is represented by a single Symbol. Identifiers and string
+// constants are interned when they are scanned. Strings created at runtime aren't interned, since the table
+// would keep them alive for as long as it is used.
+// Symbols from different tables must not be mixed, unless one table is the parent of the other.
+// A table may be used by multiple goroutines concurrently.
+type StringTable struct {
+	mu      sync.Mutex
+	parent  *StringTable // Strings interned in the parent are not added to this table again
+	symbols map[string]Symbol
+	size    int
+}
+
+func NewStringTable() *StringTable {
+	return &StringTable{symbols: map[string]Symbol{}}
+}
+
+// Returns a table that extends parent, so Symbols of both tables can be mixed. The parent is read without
+// locking, so nothing may be interned in it anymore. This allows any number of child tables to share it.
+func NewChildStringTable(parent *StringTable) *StringTable {
+	return &StringTable{parent: parent, symbols: map[string]Symbol{}}
+}
+
+// Returns the unique Symbol for the given string, adding it to the table if it hasn't been interned before
+func (t *StringTable) Intern(str string) Symbol {
+	if t.parent != nil {
+		if symbol, ok := t.parent.lookup(str); ok {
+			return symbol
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if symbol, ok := t.symbols[str]; ok {
+		return symbol
+	}
+
+	symbol := Symbol{str: &str}
+	t.symbols[str] = symbol
+	t.size += len(str) + symbolOverhead
+	return symbol
+}
+
+// Approximate memory per interned string in addition to its bytes: the string header, the pointer to it and its
+// entry in the map
+const symbolOverhead = 48
+
+// Looks up a string in a table that isn't modified anymore, and in its parents
+func (t *StringTable) lookup(str string) (Symbol, bool) {
+	if symbol, ok := t.symbols[str]; ok {
+		return symbol, true
+	}
+	if t.parent != nil {
+		return t.parent.lookup(str)
+	}
+	return Symbol{}, false
+}
+
+// Returns the approximate number of bytes used by the interned strings, not counting those of the parent
+func (t *StringTable) Size() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.size
+}
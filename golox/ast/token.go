@@ -17,6 +17,12 @@ const (
 	SEMICOLON
 	SLASH
 	STAR
+	QUESTION
+	COLON
+	PERCENT
+	AMPERSAND
+	PIPE
+	CARET
 
 	// Single or Double-char
 	BANG
@@ -25,8 +31,19 @@ const (
 	EQUAL_EQUAL
 	GREATER
 	GREATER_EQUAL
+	GREATER_GREATER
 	LESS
 	LESS_EQUAL
+	LESS_LESS
+	STAR_STAR
+	STAR_EQUAL
+	SLASH_EQUAL
+	PLUS_PLUS
+	PLUS_EQUAL
+	MINUS_MINUS
+	MINUS_EQUAL
+	TILDE
+	TILDE_SLASH
 
 	// Literals
 	IDENTIFIER
@@ -51,33 +68,142 @@ const (
 	VAR
 	WHILE
 	BREAK
+	CONTINUE
+	THROW
+	TRY
+	CATCH
+	FINALLY
+	SWITCH
+	CASE
+	DEFAULT
+	SPAWN
+	AWAIT
 
 	EOF
 )
 
+var tokenTypeNames = [...]string{
+	LEFT_PAREN:      "LEFT_PAREN",
+	RIGHT_PAREN:     "RIGHT_PAREN",
+	LEFT_BRACE:      "LEFT_BRACE",
+	RIGHT_BRACE:     "RIGHT_BRACE",
+	COMMA:           "COMMA",
+	DOT:             "DOT",
+	MINUS:           "MINUS",
+	PLUS:            "PLUS",
+	SEMICOLON:       "SEMICOLON",
+	SLASH:           "SLASH",
+	STAR:            "STAR",
+	QUESTION:        "QUESTION",
+	COLON:           "COLON",
+	PERCENT:         "PERCENT",
+	AMPERSAND:       "AMPERSAND",
+	PIPE:            "PIPE",
+	CARET:           "CARET",
+	BANG:            "BANG",
+	BANG_EQUAL:      "BANG_EQUAL",
+	EQUAL:           "EQUAL",
+	EQUAL_EQUAL:     "EQUAL_EQUAL",
+	GREATER:         "GREATER",
+	GREATER_EQUAL:   "GREATER_EQUAL",
+	GREATER_GREATER: "GREATER_GREATER",
+	LESS:            "LESS",
+	LESS_EQUAL:      "LESS_EQUAL",
+	LESS_LESS:       "LESS_LESS",
+	STAR_STAR:       "STAR_STAR",
+	STAR_EQUAL:      "STAR_EQUAL",
+	SLASH_EQUAL:     "SLASH_EQUAL",
+	PLUS_PLUS:       "PLUS_PLUS",
+	PLUS_EQUAL:      "PLUS_EQUAL",
+	MINUS_MINUS:     "MINUS_MINUS",
+	MINUS_EQUAL:     "MINUS_EQUAL",
+	TILDE:           "TILDE",
+	TILDE_SLASH:     "TILDE_SLASH",
+	IDENTIFIER:      "IDENTIFIER",
+	STRING:          "STRING",
+	NUMBER:          "NUMBER",
+	AND:             "AND",
+	CLASS:           "CLASS",
+	ELSE:            "ELSE",
+	FALSE:           "FALSE",
+	FUN:             "FUN",
+	FOR:             "FOR",
+	IF:              "IF",
+	NIL:             "NIL",
+	OR:              "OR",
+	PRINT:           "PRINT",
+	RETURN:          "RETURN",
+	SUPER:           "SUPER",
+	THIS:            "THIS",
+	TRUE:            "TRUE",
+	VAR:             "VAR",
+	WHILE:           "WHILE",
+	BREAK:           "BREAK",
+	CONTINUE:        "CONTINUE",
+	THROW:           "THROW",
+	TRY:             "TRY",
+	CATCH:           "CATCH",
+	FINALLY:         "FINALLY",
+	SWITCH:          "SWITCH",
+	CASE:            "CASE",
+	DEFAULT:         "DEFAULT",
+	SPAWN:           "SPAWN",
+	AWAIT:           "AWAIT",
+	EOF:             "EOF",
+}
+
+// Returns the name of the token type's constant, e.g. LEFT_PAREN
+func (t TokenType) String() string {
+	if t < 0 || int(t) >= len(tokenTypeNames) {
+		return fmt.Sprintf("TokenType(%d)", int(t))
+	}
+	return tokenTypeNames[t]
+}
+
+// Returns the token type with the given name, as returned by TokenType.String()
+func TokenTypeByName(name string) (TokenType, bool) {
+	for t, typeName := range tokenTypeNames {
+		if typeName == name {
+			return TokenType(t), true
+		}
+	}
+	return 0, false
+}
+
 var KeywordStrings = map[string]TokenType{
-	"and":    AND,
-	"class":  CLASS,
-	"else":   ELSE,
-	"false":  FALSE,
-	"fun":    FUN,
-	"for":    FOR,
-	"if":     IF,
-	"nil":    NIL,
-	"or":     OR,
-	"print":  PRINT,
-	"return": RETURN,
-	"super":  SUPER,
-	"this":   THIS,
-	"true":   TRUE,
-	"var":    VAR,
-	"while":  WHILE,
-	"break":  BREAK,
+	"and":      AND,
+	"class":    CLASS,
+	"else":     ELSE,
+	"false":    FALSE,
+	"fun":      FUN,
+	"for":      FOR,
+	"if":       IF,
+	"nil":      NIL,
+	"or":       OR,
+	"print":    PRINT,
+	"return":   RETURN,
+	"super":    SUPER,
+	"this":     THIS,
+	"true":     TRUE,
+	"var":      VAR,
+	"while":    WHILE,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"throw":    THROW,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
+	"switch":   SWITCH,
+	"case":     CASE,
+	"default":  DEFAULT,
+	"spawn":    SPAWN,
+	"await":    AWAIT,
 }
 
 type Token struct {
 	Type    TokenType
 	Lexeme  string
+	Symbol  Symbol // Interned Lexeme of IDENTIFIER tokens
 	Literal LoxValue
 	Line    int
 }
@@ -14,6 +14,9 @@ const (
 	LT_NUMBER // 64bit float
 	LT_BOOL
 	LT_FUNCTION
+	LT_ERROR
+	LT_TASK
+	LT_CHANNEL
 )
 
 func (t LoxType) String() string {
@@ -28,44 +31,110 @@ func (t LoxType) String() string {
 		return "Bool"
 	case LT_FUNCTION:
 		return "Function"
+	case LT_ERROR:
+		return "Error"
+	case LT_TASK:
+		return "Task"
+	case LT_CHANNEL:
+		return "Channel"
 	default:
 		panic(assert.MissingCase(t))
 	}
 }
 
+// A function created from either a declaration or an anonymous function expression.
+// Function values refer to a LoxFunction by pointer, so two of them are equal only if they were created by
+// evaluating the same declaration or expression once.
 type LoxFunction struct {
-	Declaration *FunDeclStmt
+	Name   string // Empty for anonymous functions
+	Params []Token
+	Body   []StmtID
+	Ast    *Ast           // The Ast the IDs in Body belong to
+	Native NativeFunction // Set instead of Body for functions provided by the interpreter
 }
 
+// The implementation of a function provided by the interpreter. It is called with as many arguments as the
+// function has Params. Functions that block need to return early with an error when interrupted is closed.
+type NativeFunction func(args []LoxValue, interrupted <-chan struct{}) (LoxValue, error)
+
 func (lf LoxFunction) Arity() int {
-	return len(lf.Declaration.Params)
+	return len(lf.Params)
+}
+
+// The value a runtime error is converted to when it is caught by a try statement
+type LoxError struct {
+	Message string
+	Line    int
+}
+
+// The handle of a function call started by a spawn expression. Result and Err may only be read after Done
+// has been closed.
+type LoxTask struct {
+	Done   chan struct{}
+	Result LoxValue
+	Err    error
+}
+
+// A channel to pass values between tasks, created with the capacity of its buffer. The interpreter sends and
+// receives values while holding the lock of the program's tasks, which guards all fields.
+type LoxChannel struct {
+	Capacity int
+	Buffer   []LoxValue // Values sent but not received yet, including those of senders that are still blocked
+	Received int        // Number of values received so far
+	Closed   bool
 }
 
-// A Value in Lox, represented by a type and a pointer to the actual value.
-// Use Type Assertions (see below) to extract the value
+// A Value in Lox, represented as a tagged union. Which of the payload fields is valid depends on Type:
+// Numbers and Bools are stored in num (Bools as 0 or 1), all other values in obj. String constants of the
+// program are stored as the Symbol of their interned string, so comparing two of them only compares pointers.
+// Strings created at runtime are stored as plain strings instead, so that they can be garbage collected once
+// the program doesn't use them anymore.
+// This way, creating a nil, Bool or Number value never allocates. Use the As...() methods to extract the value,
+// they may only be called for values of the matching Type.
 type LoxValue struct {
-	Type  LoxType
-	Value any
+	Type LoxType
+	num  float64
+	obj  any
 }
 
 func NewNilValue() LoxValue {
 	return LoxValue{Type: LT_NIL}
 }
 
-func NewStringValue(str string) LoxValue {
-	return LoxValue{Type: LT_STRING, Value: str}
+func NewStringValue(str Symbol) LoxValue {
+	return LoxValue{Type: LT_STRING, obj: str}
+}
+
+// Creates a String value for a string created at runtime, which isn't interned
+func NewRuntimeStringValue(str string) LoxValue {
+	return LoxValue{Type: LT_STRING, obj: str}
 }
 
 func NewNumberValue(num float64) LoxValue {
-	return LoxValue{Type: LT_NUMBER, Value: num}
+	return LoxValue{Type: LT_NUMBER, num: num}
 }
 
 func NewBoolValue(val bool) LoxValue {
-	return LoxValue{Type: LT_BOOL, Value: val}
+	if val {
+		return LoxValue{Type: LT_BOOL, num: 1}
+	}
+	return LoxValue{Type: LT_BOOL}
+}
+
+func NewFunction(fun *LoxFunction) LoxValue {
+	return LoxValue{Type: LT_FUNCTION, obj: fun}
+}
+
+func NewErrorValue(err LoxError) LoxValue {
+	return LoxValue{Type: LT_ERROR, obj: err}
+}
+
+func NewTaskValue(task *LoxTask) LoxValue {
+	return LoxValue{Type: LT_TASK, obj: task}
 }
 
-func NewFunction(fun LoxFunction) LoxValue {
-	return LoxValue{Type: LT_FUNCTION, Value: fun}
+func NewChannelValue(channel *LoxChannel) LoxValue {
+	return LoxValue{Type: LT_CHANNEL, obj: channel}
 }
 
 func (v LoxValue) IsTruthy() bool {
@@ -80,27 +149,56 @@ func (v LoxValue) IsTruthy() bool {
 }
 
 func (v LoxValue) IsEqual(other LoxValue) bool {
-	if v.Type == LT_NIL && other.Type == LT_NIL {
-		return true
+	if v.Type != other.Type {
+		return false
 	}
 
-	return v == other
+	switch v.Type {
+	case LT_NIL:
+		return true
+	case LT_NUMBER, LT_BOOL:
+		return v.num == other.num
+	case LT_STRING:
+		if a, ok := v.obj.(Symbol); ok {
+			if b, ok := other.obj.(Symbol); ok {
+				return a == b
+			}
+		}
+		return v.AsString() == other.AsString()
+	default:
+		return v.obj == other.obj
+	}
 }
 
 func (v LoxValue) AsString() string {
-	return v.Value.(string)
+	if str, ok := v.obj.(string); ok {
+		return str
+	}
+	return v.obj.(Symbol).String()
 }
 
 func (v LoxValue) AsNumber() float64 {
-	return v.Value.(float64)
+	return v.num
 }
 
 func (v LoxValue) AsBool() bool {
-	return v.Value.(bool)
+	return v.num != 0
+}
+
+func (v LoxValue) AsFunction() *LoxFunction {
+	return v.obj.(*LoxFunction)
+}
+
+func (v LoxValue) AsError() LoxError {
+	return v.obj.(LoxError)
+}
+
+func (v LoxValue) AsTask() *LoxTask {
+	return v.obj.(*LoxTask)
 }
 
-func (v LoxValue) AsFunction() LoxFunction {
-	return v.Value.(LoxFunction)
+func (v LoxValue) AsChannel() *LoxChannel {
+	return v.obj.(*LoxChannel)
 }
 
 // String representation of the LoxValue, don't confuse with AsString()!
@@ -115,9 +213,16 @@ func (v LoxValue) String() string {
 	case LT_STRING:
 		return v.AsString()
 	case LT_FUNCTION:
-		// TODO: LoxValue will be reworked anyway
-		return ""
-		//return v.AsFunction().Declaration.Tokens[0].Lexeme
+		if name := v.AsFunction().Name; name != "" {
+			return "<fn " + name + ">"
+		}
+		return "<fn>"
+	case LT_ERROR:
+		return v.AsError().Message
+	case LT_TASK:
+		return "<task>"
+	case LT_CHANNEL:
+		return "<channel>"
 	default:
 		panic(assert.MissingCase(v.Type))
 	}
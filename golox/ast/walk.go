@@ -0,0 +1,323 @@
+package ast
+
+import (
+	"fmt"
+	"toterich/golox/util/assert"
+)
+
+// A Stmt or an Expr. Walk() and Rewrite() panic for nodes of any other type, including node types they don't
+// know about yet.
+type Node any
+
+// Visits the nodes of a tree, see Walk()
+type Visitor interface {
+	// Called for each node before its children. The children are visited with the returned Visitor, followed
+	// by a call with a nil node. If the returned Visitor is nil, the children are skipped.
+	Visit(node Node) Visitor
+}
+
+// Traverses the tree rooted at node, which belongs to tree, in depth-first order. The children of a node are
+// visited in the order in which they are executed, e.g. the condition of a loop before its body and the body
+// before the increment. Nodes of function bodies are visited as children of the function's declaration or
+// expression.
+func Walk(tree *Ast, v Visitor, node Node) {
+	v = v.Visit(node)
+	if v == nil {
+		return
+	}
+
+	expr := func(id ExprID) { Walk(tree, v, tree.Expr(id)) }
+	stmt := func(id StmtID) { Walk(tree, v, tree.Stmt(id)) }
+	// Walks an optional child unless it is absent, like the else branch of an if statement without one
+	optionalExpr := func(id ExprID) {
+		if id != 0 {
+			expr(id)
+		}
+	}
+	optionalStmt := func(id StmtID) {
+		if id != 0 {
+			stmt(id)
+		}
+	}
+
+	switch node := node.(type) {
+	case *ExprStmt:
+		expr(node.Expr)
+	case *PrintStmt:
+		expr(node.Expr)
+	case *VarDeclStmt:
+		optionalExpr(node.Value)
+	case *BlockStmt:
+		walkList(node.Body, stmt)
+	case *IfStmt:
+		expr(node.Condition)
+		stmt(node.Then)
+		optionalStmt(node.Else)
+	case *WhileStmt:
+		expr(node.Condition)
+		stmt(node.Then)
+		optionalExpr(node.Increment)
+	case *SwitchStmt:
+		expr(node.Value)
+		for _, case_ := range node.Cases {
+			walkList(case_.Values, expr)
+			stmt(case_.Body)
+		}
+		optionalStmt(node.Default)
+	case *BreakStmt, *ContinueStmt:
+	case *FunDeclStmt:
+		walkList(node.Body, stmt)
+	case *ReturnStmt:
+		optionalExpr(node.Value)
+	case *ThrowStmt:
+		expr(node.Value)
+	case *TryStmt:
+		stmt(node.Body)
+		optionalStmt(node.Catch)
+		optionalStmt(node.Finally)
+
+	case *LiteralExpr, *IdentifierExpr, *IncrementExpr:
+	case *UnaryExpr:
+		expr(node.Operand)
+	case *BinaryExpr:
+		expr(node.Left)
+		expr(node.Right)
+	case *SequenceExpr:
+		walkList(node.Exprs, expr)
+	case *ConditionalExpr:
+		expr(node.Condition)
+		expr(node.Then)
+		expr(node.Else)
+	case *GroupingExpr:
+		expr(node.Grouped)
+	case *AssignExpr:
+		expr(node.Value)
+	case *CompoundAssignExpr:
+		expr(node.Value)
+	case *OrExpr:
+		expr(node.Left)
+		expr(node.Right)
+	case *AndExpr:
+		expr(node.Left)
+		expr(node.Right)
+	case *CallExpr:
+		expr(node.Callee)
+		walkList(node.Arguments, expr)
+	case *GetExpr:
+		expr(node.Object)
+	case *FunctionExpr:
+		walkList(node.Body, stmt)
+	case *SpawnExpr:
+		expr(node.Call)
+	case *AwaitExpr:
+		expr(node.Task)
+
+	default:
+		panic(assert.MissingCase(node))
+	}
+
+	v.Visit(nil)
+}
+
+func walkList[ID ExprID | StmtID](ids []ID, walk func(ID)) {
+	for _, id := range ids {
+		walk(id)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Traverses the tree rooted at node in the same order as Walk(). f is called for each node, and afterwards
+// with nil once all of the node's children have been visited. If f returns false, the children are skipped.
+func Inspect(tree *Ast, node Node, f func(Node) bool) {
+	Walk(tree, inspector(f), node)
+}
+
+// Replaces the nodes of the tree rooted at node, which belongs to tree, by the results of f. f is called for
+// each node after its children have been rewritten, and returns the node itself to keep it. New nodes must be
+// allocated by the stores of tree, so that they have an ID the parent can refer to them by. The tree is
+// modified in place, and the rewritten root is returned.
+//
+// f may return nil for statements in a list, like the body of a block, to remove them, and for children that
+// are optional, like the else branch of an if statement. Rewrite panics if f returns nil for any other node, a
+// statement in place of an expression or the other way around, a node that wasn't allocated by tree, or
+// anything but a CallExpr for the call of a SpawnExpr.
+func Rewrite(tree *Ast, node Node, f func(Node) Node) Node {
+	r := rewriter{tree: tree, f: f}
+	return r.rewrite(node)
+}
+
+// Rewrites each statement of a list like Rewrite(), and returns the list without the statements f removed
+func RewriteList(tree *Ast, stmts []StmtID, f func(Node) Node) []StmtID {
+	r := rewriter{tree: tree, f: f}
+	return r.list(stmts)
+}
+
+type rewriter struct {
+	tree *Ast
+	f    func(Node) Node
+}
+
+func (r rewriter) rewrite(node Node) Node {
+	switch node := node.(type) {
+	case *ExprStmt:
+		node.Expr = r.expr(node.Expr)
+	case *PrintStmt:
+		node.Expr = r.expr(node.Expr)
+	case *VarDeclStmt:
+		node.Value = r.optionalExpr(node.Value)
+	case *BlockStmt:
+		node.Body = r.list(node.Body)
+	case *IfStmt:
+		node.Condition = r.expr(node.Condition)
+		node.Then = r.stmt(node.Then)
+		node.Else = r.optionalStmt(node.Else)
+	case *WhileStmt:
+		node.Condition = r.expr(node.Condition)
+		node.Then = r.stmt(node.Then)
+		node.Increment = r.optionalExpr(node.Increment)
+	case *SwitchStmt:
+		node.Value = r.expr(node.Value)
+		for idx := range node.Cases {
+			case_ := &node.Cases[idx]
+			for idx, value := range case_.Values {
+				case_.Values[idx] = r.expr(value)
+			}
+			case_.Body = r.stmt(case_.Body)
+		}
+		node.Default = r.optionalStmt(node.Default)
+	case *BreakStmt, *ContinueStmt:
+	case *FunDeclStmt:
+		node.Body = r.list(node.Body)
+	case *ReturnStmt:
+		node.Value = r.optionalExpr(node.Value)
+	case *ThrowStmt:
+		node.Value = r.expr(node.Value)
+	case *TryStmt:
+		node.Body = r.stmt(node.Body)
+		node.Catch = r.optionalStmt(node.Catch)
+		node.Finally = r.optionalStmt(node.Finally)
+
+	case *LiteralExpr, *IdentifierExpr, *IncrementExpr:
+	case *UnaryExpr:
+		node.Operand = r.expr(node.Operand)
+	case *BinaryExpr:
+		node.Left = r.expr(node.Left)
+		node.Right = r.expr(node.Right)
+	case *SequenceExpr:
+		for idx, e := range node.Exprs {
+			node.Exprs[idx] = r.expr(e)
+		}
+	case *ConditionalExpr:
+		node.Condition = r.expr(node.Condition)
+		node.Then = r.expr(node.Then)
+		node.Else = r.expr(node.Else)
+	case *GroupingExpr:
+		node.Grouped = r.expr(node.Grouped)
+	case *AssignExpr:
+		node.Value = r.expr(node.Value)
+	case *CompoundAssignExpr:
+		node.Value = r.expr(node.Value)
+	case *OrExpr:
+		node.Left = r.expr(node.Left)
+		node.Right = r.expr(node.Right)
+	case *AndExpr:
+		node.Left = r.expr(node.Left)
+		node.Right = r.expr(node.Right)
+	case *CallExpr:
+		node.Callee = r.expr(node.Callee)
+		for idx, argument := range node.Arguments {
+			node.Arguments[idx] = r.expr(argument)
+		}
+	case *GetExpr:
+		node.Object = r.expr(node.Object)
+	case *FunctionExpr:
+		node.Body = r.list(node.Body)
+	case *SpawnExpr:
+		node.Call = r.expr(node.Call)
+		if _, ok := r.tree.Expr(node.Call).(*CallExpr); !ok {
+			panic("a SpawnExpr can only be rewritten to spawn a CallExpr")
+		}
+	case *AwaitExpr:
+		node.Task = r.expr(node.Task)
+
+	default:
+		panic(assert.MissingCase(node))
+	}
+
+	return r.f(node)
+}
+
+func (r rewriter) list(stmts []StmtID) []StmtID {
+	rewritten := stmts[:0]
+	for _, stmt := range stmts {
+		replacement := r.rewrite(r.tree.Stmt(stmt))
+		if replacement == nil {
+			continue
+		}
+		rewritten = append(rewritten, r.stmtID(replacement))
+	}
+	return rewritten
+}
+
+func (r rewriter) stmt(id StmtID) StmtID {
+	return r.stmtID(r.rewrite(r.tree.Stmt(id)))
+}
+
+func (r rewriter) expr(id ExprID) ExprID {
+	return r.exprID(r.rewrite(r.tree.Expr(id)))
+}
+
+// Rewrites a child unless it is absent, and allows f to remove it
+func (r rewriter) optionalStmt(id StmtID) StmtID {
+	if id == 0 {
+		return 0
+	}
+	replacement := r.rewrite(r.tree.Stmt(id))
+	if replacement == nil {
+		return 0
+	}
+	return r.stmtID(replacement)
+}
+
+func (r rewriter) optionalExpr(id ExprID) ExprID {
+	if id == 0 {
+		return 0
+	}
+	replacement := r.rewrite(r.tree.Expr(id))
+	if replacement == nil {
+		return 0
+	}
+	return r.exprID(replacement)
+}
+
+// Returns the ID of a replacement statement, after checking that it belongs to the tree
+func (r rewriter) stmtID(node Node) StmtID {
+	stmt, ok := node.(Stmt)
+	if !ok {
+		panic(fmt.Sprintf("can't replace a statement by %T", node))
+	}
+	if r.tree.Stmt(stmt.ID()) != stmt {
+		panic(fmt.Sprintf("the replacement %T wasn't allocated by the Ast's StmtStore", stmt))
+	}
+	return stmt.ID()
+}
+
+// Returns the ID of a replacement expression, after checking that it belongs to the tree
+func (r rewriter) exprID(node Node) ExprID {
+	expr, ok := node.(Expr)
+	if !ok {
+		panic(fmt.Sprintf("can't replace an expression by %T", node))
+	}
+	if r.tree.Expr(expr.ID()) != expr {
+		panic(fmt.Sprintf("the replacement %T wasn't allocated by the Ast's ExprStore", expr))
+	}
+	return expr.ID()
+}
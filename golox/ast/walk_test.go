@@ -0,0 +1,186 @@
+package ast_test
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"toterich/golox/ast"
+)
+
+// Uses every type of statement and expression
+const everyNode = `fun add(a: Number, b): Number { return a + b; }
+for (var i = 0; i < 3; i++) {
+  if (i == 1) continue; else print add(i, 2);
+}
+var f = fun (x) { return x * 2; };
+switch (f(1)) { case 1, 2: print "a b"; default: break; }
+try { throw "x"; } catch (e) { print e.message; } finally { print true or false and nil; }
+var t = spawn add(1, 2);
+print await t;
+var s = (1, 2) ? -3 : !4;
+i += 1;
+i = 2;
+`
+
+func TestInspectVisitsEveryNode(t *testing.T) {
+	tree, errs := parseProgram(everyNode)
+	if errs != nil {
+		t.Fatal(errs)
+	}
+
+	var visited []string
+	depth := 0
+	for _, stmt := range tree.Body {
+		ast.Inspect(tree, tree.Stmt(stmt), func(node ast.Node) bool {
+			if node == nil {
+				depth -= 1
+				return false
+			}
+			depth += 1
+			if name := fmt.Sprintf("%T", node); !slices.Contains(visited, name) {
+				visited = append(visited, name)
+			}
+			return true
+		})
+	}
+	if depth != 0 {
+		t.Errorf("expected a nil node after the children of each node, %d are missing", depth)
+	}
+
+	slices.Sort(visited)
+	expected := []string{
+		"*ast.AndExpr", "*ast.AssignExpr", "*ast.AwaitExpr", "*ast.BinaryExpr", "*ast.BlockStmt", "*ast.BreakStmt",
+		"*ast.CallExpr", "*ast.CompoundAssignExpr", "*ast.ConditionalExpr", "*ast.ContinueStmt", "*ast.ExprStmt",
+		"*ast.FunDeclStmt", "*ast.FunctionExpr", "*ast.GetExpr", "*ast.GroupingExpr", "*ast.IdentifierExpr",
+		"*ast.IfStmt", "*ast.IncrementExpr", "*ast.LiteralExpr", "*ast.OrExpr", "*ast.PrintStmt", "*ast.ReturnStmt",
+		"*ast.SequenceExpr", "*ast.SpawnExpr", "*ast.SwitchStmt", "*ast.ThrowStmt", "*ast.TryStmt", "*ast.UnaryExpr",
+		"*ast.VarDeclStmt", "*ast.WhileStmt",
+	}
+	if !slices.Equal(visited, expected) {
+		t.Errorf("expected to visit\n%v\ngot\n%v", expected, visited)
+	}
+}
+
+func TestWalkOrder(t *testing.T) {
+	tree, errs := parseProgram("for (var i = 0; i < 3; i++) print i;")
+	if errs != nil {
+		t.Fatal(errs)
+	}
+
+	var visited []string
+	ast.Inspect(tree, tree.Stmt(tree.Body[0]), func(node ast.Node) bool {
+		if node != nil {
+			visited = append(visited, fmt.Sprintf("%T", node))
+		}
+		return true
+	})
+
+	// The loop's condition is executed before its body, and the increment after it
+	expected := []string{
+		"*ast.BlockStmt", "*ast.VarDeclStmt", "*ast.LiteralExpr", "*ast.WhileStmt",
+		"*ast.BinaryExpr", "*ast.IdentifierExpr", "*ast.LiteralExpr",
+		"*ast.PrintStmt", "*ast.IdentifierExpr",
+		"*ast.IncrementExpr",
+	}
+	if !slices.Equal(visited, expected) {
+		t.Errorf("expected to visit\n%v\ngot\n%v", expected, visited)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	tree, errs := parseProgram(`
+if (1 + 2 * 3 > 5) {
+  print "big";
+  print 10 - 4;
+} else {
+  print "small";
+}
+`)
+	if errs != nil {
+		t.Fatal(errs)
+	}
+
+	// Folds additions and multiplications of numbers, and removes the prints of strings
+	tree.Body = ast.RewriteList(tree, tree.Body, func(node ast.Node) ast.Node {
+		switch node := node.(type) {
+		case *ast.BinaryExpr:
+			left, leftOk := tree.Expr(node.Left).(*ast.LiteralExpr)
+			right, rightOk := tree.Expr(node.Right).(*ast.LiteralExpr)
+			if !leftOk || !rightOk || left.Token.Type != ast.NUMBER || right.Token.Type != ast.NUMBER {
+				return node
+			}
+			a, b := left.Token.Literal.AsNumber(), right.Token.Literal.AsNumber()
+			var result float64
+			switch node.Operator.Type {
+			case ast.PLUS:
+				result = a + b
+			case ast.STAR:
+				result = a * b
+			default:
+				return node
+			}
+			return tree.Expressions.NewLiteralExpr(ast.Token{
+				Type:    ast.NUMBER,
+				Lexeme:  fmt.Sprint(result),
+				Literal: ast.NewNumberValue(result),
+				Line:    node.Operator.Line,
+			})
+		case *ast.PrintStmt:
+			if literal, ok := tree.Expr(node.Expr).(*ast.LiteralExpr); ok && literal.Token.Type == ast.STRING {
+				return nil
+			}
+		case *ast.BlockStmt:
+			// Blocks left empty are removed, the else branch is optional
+			if len(node.Body) == 0 {
+				return nil
+			}
+		}
+		return node
+	})
+
+	expected := `(IfStmt @2 (BinaryExpr >@2 (LiteralExpr 7@2) (LiteralExpr 5@2))
+  (BlockStmt @2
+    (PrintStmt @4 (BinaryExpr -@4 (LiteralExpr 10@4) (LiteralExpr 4@4)))))
+`
+	if actual := ast.FormatSExpr(tree); actual != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, actual)
+	}
+}
+
+// Replacements need to be expressions in place of expressions and statements in place of statements, and
+// allocated by the tree's stores, as otherwise their IDs would resolve to other nodes
+func TestRewritePanicsForWrongNodes(t *testing.T) {
+	var store ast.ExprStore
+	tests := map[string]func(tree *ast.Ast, literal *ast.LiteralExpr) ast.Node{
+		"a statement in place of an expression": func(tree *ast.Ast, literal *ast.LiteralExpr) ast.Node {
+			return tree.Stmt(tree.Body[0])
+		},
+		"an expression from another store": func(tree *ast.Ast, literal *ast.LiteralExpr) ast.Node {
+			return store.NewLiteralExpr(literal.Token)
+		},
+		"an expression without ID": func(tree *ast.Ast, literal *ast.LiteralExpr) ast.Node {
+			return &ast.LiteralExpr{Token: literal.Token}
+		},
+	}
+
+	for name, replace := range tests {
+		t.Run(name, func(t *testing.T) {
+			tree, errs := parseProgram("print 1;")
+			if errs != nil {
+				t.Fatal(errs)
+			}
+
+			defer func() {
+				if recover() == nil {
+					t.Errorf("replacing the literal by %s didn't panic", name)
+				}
+			}()
+			ast.RewriteList(tree, tree.Body, func(node ast.Node) ast.Node {
+				if literal, ok := node.(*ast.LiteralExpr); ok {
+					return replace(tree, literal)
+				}
+				return node
+			})
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package conformance
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Samples that can't pass yet, with the reason why
+var knownFailures = map[string]string{
+	"classes.lox":  "classes are not implemented yet",
+	"closures.lox": "functions don't capture their enclosing scope yet",
+}
+
+func runDir(t *testing.T, dir string) {
+	results, err := RunDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) == 0 {
+		t.Fatalf("no scripts found in %s", dir)
+	}
+
+	for _, result := range results {
+		t.Run(filepath.Base(result.File), func(t *testing.T) {
+			if reason, ok := knownFailures[filepath.Base(result.File)]; ok {
+				t.Skip(reason)
+			}
+			if !result.Passed() {
+				t.Error(strings.Join(result.Failures, "\n"))
+			}
+		})
+	}
+}
+
+func TestSamples(t *testing.T) {
+	runDir(t, "../../lox_spec/samples")
+}
+
+func TestSpec(t *testing.T) {
+	runDir(t, "../../lox_spec/tests")
+}
+
+func TestReportsMismatches(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mismatch.lox")
+	source := `
+print 1; // expect: 2
+print 3;
+print 4 / nil; // expect runtime error: division by zero
+`
+	err := os.WriteFile(file, []byte(source), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := RunFile(file)
+	expected := []string{
+		`output 1: expected "2", got "1"`,
+		`unexpected output 2: got "3"`,
+		`runtime error: expected "division by zero" on line 4, got "Expected [Number Number] as arguments, got [Number nil]" on line 4`,
+	}
+	if strings.Join(result.Failures, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected failures:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(result.Failures, "\n"))
+	}
+}
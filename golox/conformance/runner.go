@@ -0,0 +1,226 @@
+// Package conformance runs Lox scripts and compares their behavior to the expectations annotated in them.
+//
+// Expectations are comments in the script:
+//
+//	print 1 + 2; // expect: 3
+//	print 1 / 0; // expect runtime error: division by zero
+//	print (;     // [line 3] Syntax Error at Token ';': expected expression.
+//
+// "expect:" gives the next line the script prints, in the order the comments appear in the script.
+// "expect runtime error:" gives the message of the runtime error or uncaught exception the script ends with,
+// which needs to occur on the line of the comment.
+// "[line N] ..." gives an error reported before the script is executed, exactly as golox reports it.
+// A script without expectations must run without printing anything.
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"toterich/golox/interp"
+	"toterich/golox/util"
+)
+
+// The time a script may run before it is considered to hang
+const Timeout = 10 * time.Second
+
+// The outcome of running a single script
+type Result struct {
+	File     string
+	Failures []string // Describes every expectation that wasn't met, empty if the script passed
+}
+
+func (r Result) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+type expectedRuntimeError struct {
+	line int
+	msg  string
+}
+
+type expectations struct {
+	output       []string
+	compileErrs  []string
+	runtimeError *expectedRuntimeError
+}
+
+var (
+	expectOutputRe       = regexp.MustCompile(`// expect: ?(.*)$`)
+	expectRuntimeErrorRe = regexp.MustCompile(`// expect runtime error: (.+)$`)
+	expectCompileErrorRe = regexp.MustCompile(`// (\[line \d+\] .+)$`)
+)
+
+func parseExpectations(source string) (expectations, error) {
+	var expected expectations
+
+	for idx, line := range strings.Split(source, "\n") {
+		if match := expectOutputRe.FindStringSubmatch(line); match != nil {
+			expected.output = append(expected.output, match[1])
+		} else if match := expectRuntimeErrorRe.FindStringSubmatch(line); match != nil {
+			if expected.runtimeError != nil {
+				return expected, fmt.Errorf("line %d: a script can only end with one runtime error", idx+1)
+			}
+			expected.runtimeError = &expectedRuntimeError{line: idx + 1, msg: match[1]}
+		} else if match := expectCompileErrorRe.FindStringSubmatch(line); match != nil {
+			expected.compileErrs = append(expected.compileErrs, match[1])
+		}
+	}
+
+	return expected, nil
+}
+
+// Runs a single script and checks it against its expectations
+func RunFile(file string) (result Result) {
+	result.File = file
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		result.Failures = append(result.Failures, err.Error())
+		return result
+	}
+	source := string(data)
+
+	expected, err := parseExpectations(source)
+	if err != nil {
+		result.Failures = append(result.Failures, err.Error())
+		return result
+	}
+
+	// A crashing script must not take down the others
+	defer func() {
+		if r := recover(); r != nil {
+			result.Failures = append(result.Failures, fmt.Sprintf("panic: %v", r))
+		}
+	}()
+
+	program, errs := interp.Compile(source)
+	var compileErrs []string
+	for _, err := range errs {
+		compileErrs = append(compileErrs, util.FormatError(err))
+	}
+	result.Failures = append(result.Failures, compareLines("error", expected.compileErrs, compileErrs)...)
+	if program == nil {
+		return result
+	}
+
+	var out lockedBuffer
+	interpreter := program.NewInterpreter()
+	interpreter.SetOutput(&out)
+
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	errs = interpreter.Run(ctx, program)
+
+	output := strings.Split(out.String(), "\n")
+	output = output[:len(output)-1] // The output ends with a newline, unless it is empty
+	result.Failures = append(result.Failures, compareLines("output", expected.output, output)...)
+	result.Failures = append(result.Failures, compareRuntimeError(expected.runtimeError, errs)...)
+
+	return result
+}
+
+// Runs all scripts in dir and its subdirectories, in lexical order
+func RunDir(dir string) ([]Result, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() && filepath.Ext(path) == ".lox" {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Result
+	for _, file := range files {
+		results = append(results, RunFile(file))
+	}
+	return results, nil
+}
+
+// Describes the differences between the expected and actual lines of output or errors
+func compareLines(kind string, expected []string, actual []string) []string {
+	var failures []string
+
+	for idx := range max(len(expected), len(actual)) {
+		switch {
+		case idx >= len(actual):
+			failures = append(failures, fmt.Sprintf("missing %s %d: expected %q", kind, idx+1, expected[idx]))
+		case idx >= len(expected):
+			failures = append(failures, fmt.Sprintf("unexpected %s %d: got %q", kind, idx+1, actual[idx]))
+		case expected[idx] != actual[idx]:
+			failures = append(failures, fmt.Sprintf("%s %d: expected %q, got %q", kind, idx+1, expected[idx], actual[idx]))
+		}
+	}
+
+	return failures
+}
+
+func compareRuntimeError(expected *expectedRuntimeError, errs []error) []string {
+	if len(errs) > 1 {
+		errs = errs[:1]
+	}
+	if expected == nil {
+		if errs == nil {
+			return nil
+		}
+		return []string{fmt.Sprintf("unexpected runtime error: %s", util.FormatError(errs[0]))}
+	}
+
+	if errs == nil {
+		return []string{fmt.Sprintf("missing runtime error on line %d: expected %q", expected.line, expected.msg)}
+	}
+
+	line, msg := runtimeErrorMessage(errs[0])
+	if line != expected.line || msg != expected.msg {
+		return []string{fmt.Sprintf("runtime error: expected %q on line %d, got %q on line %d", expected.msg, expected.line, msg, line)}
+	}
+	return nil
+}
+
+// Returns the line and the message of an error that ended a script
+func runtimeErrorMessage(err error) (int, string) {
+	{
+		var e util.RuntimeError
+		if errors.As(err, &e) {
+			return e.Token.Line, e.Msg
+		}
+	}
+
+	{
+		var e util.LoxException
+		if errors.As(err, &e) {
+			return e.Token.Line, fmt.Sprintf("Uncaught exception: %s", e.Value)
+		}
+	}
+
+	return 0, err.Error()
+}
+
+// Collects the output of a script, whose tasks may print concurrently
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,210 @@
+package grammar
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"toterich/golox/util/assert"
+)
+
+// A rule that is being expanded
+type Frame struct {
+	Rule string
+	ID   int // Distinguishes the expansions of the same rule in one program
+}
+
+// Restricts the programs a Generator produces, for languages whose parser accepts less than their grammar,
+// e.g. because a statement is only valid inside certain other statements
+type Constraints interface {
+	// Reports whether rule may be expanded inside the enclosing rules, the innermost one last
+	Allow(rule string, enclosing []Frame) bool
+	// Reports whether the text a rule has been expanded to is acceptable. If it isn't, the generator chooses
+	// a different expansion.
+	Accept(rule string, text string, enclosing []Frame) bool
+}
+
+// Generates random programs from a grammar
+type Generator struct {
+	// The maximal depth of nested rules. Beyond it, the generator chooses the expansions that nest the least,
+	// so programs may still exceed it when the grammar doesn't allow anything shallower.
+	MaxDepth int
+	// Generates the text of each terminal the grammar refers to
+	Terminals map[string]func(r *rand.Rand) string
+	// Optional restrictions of the generated programs
+	Constraints Constraints
+	// Returns the probability that an optional or repeated item of rule appears another time, when rule is
+	// nested depth rules deep. The default gets smaller the deeper rule is nested, which keeps programs small.
+	RepeatProbability func(rule string, depth int) float64
+	// Joins the generated tokens into a program. The default separates them by spaces.
+	Layout func(tokens []string) string
+
+	grammar *Grammar
+	rand    *rand.Rand
+	tokens  []string
+	frames  []Frame
+	nextID  int
+}
+
+const DefaultMaxDepth = 40
+
+// Creates a Generator for the grammar whose programs are determined by the seed
+func NewGenerator(grammar *Grammar, seed uint64) *Generator {
+	generator := &Generator{
+		MaxDepth:  DefaultMaxDepth,
+		Terminals: map[string]func(r *rand.Rand) string{},
+		Layout:    func(tokens []string) string { return strings.Join(tokens, " ") },
+		grammar:   grammar,
+		rand:      rand.New(rand.NewPCG(seed, seed)),
+	}
+	generator.RepeatProbability = func(rule string, depth int) float64 {
+		return generator.remainingDepth(depth) / 2
+	}
+	return generator
+}
+
+// Returns the fraction of the maximal depth that remains at the given depth
+func (g *Generator) remainingDepth(depth int) float64 {
+	return max(0, float64(g.MaxDepth-depth)/float64(g.MaxDepth))
+}
+
+// Generates the next program, derived from the grammar's start rule
+func (g *Generator) Generate() (string, error) {
+	for _, rule := range g.grammar.Rules {
+		if terminal := g.findMissingTerminal(rule); terminal != "" {
+			return "", fmt.Errorf("no text is generated for terminal %s", terminal)
+		}
+	}
+
+	g.tokens = g.tokens[:0]
+	g.frames = g.frames[:0]
+	if !g.expand(Reference{Name: g.grammar.Start}) {
+		return "", fmt.Errorf("the constraints reject every program")
+	}
+
+	return g.Layout(g.tokens), nil
+}
+
+// Returns the name of a terminal node refers to which the generator has no function for, or "" if there is none
+func (g *Generator) findMissingTerminal(node Node) string {
+	switch node := node.(type) {
+	case Reference:
+		if _, ok := g.Terminals[node.Name]; !ok && IsTerminal(node.Name) {
+			return node.Name
+		}
+	case Sequence:
+		for _, item := range node.Items {
+			if terminal := g.findMissingTerminal(item); terminal != "" {
+				return terminal
+			}
+		}
+	case Choice:
+		for _, alternative := range node.Alternatives {
+			if terminal := g.findMissingTerminal(alternative); terminal != "" {
+				return terminal
+			}
+		}
+	case Repeat:
+		return g.findMissingTerminal(node.Item)
+	}
+	return ""
+}
+
+// Returns whether node can be expanded within the maximal depth
+func (g *Generator) fits(node Node) bool {
+	return len(g.frames)+g.grammar.height(node) <= g.MaxDepth
+}
+
+// Appends a random expansion of node to the generated tokens. If the constraints reject every expansion,
+// nothing is appended and false is returned.
+func (g *Generator) expand(node Node) bool {
+	mark := len(g.tokens)
+
+	switch node := node.(type) {
+	case Literal:
+		g.tokens = append(g.tokens, node.Text)
+		return true
+
+	case Reference:
+		if IsTerminal(node.Name) {
+			// Terminals may be empty, like EOF
+			if text := g.Terminals[node.Name](g.rand); text != "" {
+				g.tokens = append(g.tokens, text)
+			}
+			return true
+		}
+
+		if g.Constraints != nil && !g.Constraints.Allow(node.Name, g.frames) {
+			return false
+		}
+
+		g.frames = append(g.frames, Frame{Rule: node.Name, ID: g.nextID})
+		g.nextID += 1
+		ok := g.expand(g.grammar.Rules[node.Name])
+		g.frames = g.frames[:len(g.frames)-1]
+
+		if ok && g.Constraints != nil {
+			ok = g.Constraints.Accept(node.Name, strings.Join(g.tokens[mark:], " "), g.frames)
+		}
+		if !ok {
+			g.tokens = g.tokens[:mark]
+		}
+		return ok
+
+	case Sequence:
+		for _, item := range node.Items {
+			if !g.expand(item) {
+				g.tokens = g.tokens[:mark]
+				return false
+			}
+		}
+		return true
+
+	case Choice:
+		// Try the alternatives that fit within the maximal depth in random order, then the others from the
+		// shallowest to the deepest, until one of them is accepted
+		var fitting, others []Node
+		for _, alternative := range node.Alternatives {
+			if g.fits(alternative) {
+				fitting = append(fitting, alternative)
+			} else {
+				others = append(others, alternative)
+			}
+		}
+		g.rand.Shuffle(len(fitting), func(i, j int) { fitting[i], fitting[j] = fitting[j], fitting[i] })
+		slices.SortStableFunc(others, func(a, b Node) int { return g.grammar.height(a) - g.grammar.height(b) })
+
+		for _, alternative := range append(fitting, others...) {
+			if g.expand(alternative) {
+				return true
+			}
+		}
+		return false
+
+	case Repeat:
+		for count := 0; node.Max == Unbounded || count < node.Max; count++ {
+			if count >= node.Min && !(g.fits(node.Item) && g.repeatAgain()) {
+				break
+			}
+
+			itemMark := len(g.tokens)
+			if !g.expand(node.Item) {
+				if count < node.Min {
+					g.tokens = g.tokens[:mark]
+					return false
+				}
+				g.tokens = g.tokens[:itemMark]
+				break
+			}
+		}
+		return true
+	}
+
+	panic(assert.MissingCase(node))
+}
+
+// Decides whether an optional or repeated node of the innermost rule appears another time
+func (g *Generator) repeatAgain() bool {
+	rule := g.frames[len(g.frames)-1].Rule
+	return g.rand.Float64() < g.RepeatProbability(rule, len(g.frames))
+}
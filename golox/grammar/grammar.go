@@ -0,0 +1,402 @@
+// Package grammar reads the EBNF-like grammar in lox_spec/grammar.txt and generates random programs from it,
+// which is used to check that the parser accepts everything the grammar allows and that different backends
+// agree on the output of the same program.
+//
+// A grammar is a list of rules of the form
+//
+//	name -> alternative | alternative ... ;
+//
+// Each alternative is a sequence of quoted literals, names and parenthesized groups, each of which may be
+// followed by "?", "*" or "+". Names in all capitals, like IDENTIFIER or NUMBER, are terminals whose text is
+// supplied by the generator, all other names refer to rules.
+package grammar
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"toterich/golox/util/assert"
+)
+
+// A part of the right-hand side of a rule
+type Node interface {
+	node()
+}
+
+// Text that appears literally in a program, e.g. "fun"
+type Literal struct {
+	Text string
+}
+
+// A rule or a terminal
+type Reference struct {
+	Name string
+}
+
+// Nodes that appear one after another
+type Sequence struct {
+	Items []Node
+}
+
+// Nodes of which exactly one appears
+type Choice struct {
+	Alternatives []Node
+}
+
+// A node that appears between Min and Max times
+type Repeat struct {
+	Item Node
+	Min  int
+	Max  int // Unbounded if there is no maximum
+}
+
+const Unbounded = -1
+
+func (Literal) node()   {}
+func (Reference) node() {}
+func (Sequence) node()  {}
+func (Choice) node()    {}
+func (Repeat) node()    {}
+
+type Grammar struct {
+	Start string          // The rule programs are derived from, which is the first rule of the grammar
+	Rules map[string]Node // The right-hand side of each rule
+
+	// The minimal depth of nested rules it takes to derive text from each rule
+	heights map[string]int
+}
+
+func IsTerminal(name string) bool {
+	return strings.ToUpper(name) == name
+}
+
+// Parses the rules of a grammar. Returns an error if the grammar is malformed, refers to rules that aren't
+// defined, or contains rules from which no finite text can be derived.
+func Parse(source string) (*Grammar, error) {
+	tokens, err := scan(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser{tokens: tokens}
+	grammar := &Grammar{Rules: map[string]Node{}}
+
+	for !p.isAtEnd() {
+		name, err := p.consume(tokenName, "rule name")
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := grammar.Rules[name.text]; ok {
+			return nil, fmt.Errorf("line %d: rule %s is defined more than once", name.line, name.text)
+		}
+		if IsTerminal(name.text) {
+			return nil, fmt.Errorf("line %d: %s is the name of a terminal and can't be defined", name.line, name.text)
+		}
+		_, err = p.consume(tokenArrow, "'->'")
+		if err != nil {
+			return nil, err
+		}
+		rule, err := p.parseChoice()
+		if err != nil {
+			return nil, err
+		}
+		_, err = p.consume(tokenSemicolon, "';' after rule")
+		if err != nil {
+			return nil, err
+		}
+
+		if grammar.Start == "" {
+			grammar.Start = name.text
+		}
+		grammar.Rules[name.text] = rule
+	}
+
+	if grammar.Start == "" {
+		return nil, fmt.Errorf("grammar has no rules")
+	}
+
+	for name, rule := range grammar.Rules {
+		if undefined := grammar.findUndefined(rule); undefined != "" {
+			return nil, fmt.Errorf("rule %s refers to undefined rule %s", name, undefined)
+		}
+	}
+
+	err = grammar.computeHeights()
+	if err != nil {
+		return nil, err
+	}
+
+	return grammar, nil
+}
+
+// Returns the name of a rule node refers to which isn't defined, or "" if there is none
+func (g *Grammar) findUndefined(node Node) string {
+	switch node := node.(type) {
+	case Reference:
+		if _, ok := g.Rules[node.Name]; !ok && !IsTerminal(node.Name) {
+			return node.Name
+		}
+	case Sequence:
+		for _, item := range node.Items {
+			if undefined := g.findUndefined(item); undefined != "" {
+				return undefined
+			}
+		}
+	case Choice:
+		for _, alternative := range node.Alternatives {
+			if undefined := g.findUndefined(alternative); undefined != "" {
+				return undefined
+			}
+		}
+	case Repeat:
+		return g.findUndefined(node.Item)
+	}
+	return ""
+}
+
+// Computes the height of every rule by iterating until no height changes anymore. A rule that still has an
+// infinite height afterwards always refers to itself.
+func (g *Grammar) computeHeights() error {
+	g.heights = map[string]int{}
+	for name := range g.Rules {
+		g.heights[name] = math.MaxInt
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for name, rule := range g.Rules {
+			height := g.height(rule)
+			if height < g.heights[name] {
+				g.heights[name] = height
+				changed = true
+			}
+		}
+	}
+
+	for name, height := range g.heights {
+		if height == math.MaxInt {
+			return fmt.Errorf("rule %s can't derive any text without referring to itself", name)
+		}
+	}
+	return nil
+}
+
+// Returns the minimal depth of nested rules it takes to derive text from node, or math.MaxInt if this is not
+// possible according to the heights computed so far
+func (g *Grammar) height(node Node) int {
+	switch node := node.(type) {
+	case Literal:
+		return 0
+	case Reference:
+		if IsTerminal(node.Name) {
+			return 0
+		}
+		height := g.heights[node.Name]
+		if height == math.MaxInt {
+			return height
+		}
+		return height + 1
+	case Sequence:
+		height := 0
+		for _, item := range node.Items {
+			height = max(height, g.height(item))
+		}
+		return height
+	case Choice:
+		height := math.MaxInt
+		for _, alternative := range node.Alternatives {
+			height = min(height, g.height(alternative))
+		}
+		return height
+	case Repeat:
+		if node.Min == 0 {
+			return 0
+		}
+		return g.height(node.Item)
+	}
+	panic(assert.MissingCase(node))
+}
+
+type tokenType int
+
+const (
+	tokenName tokenType = iota
+	tokenLiteral
+	tokenArrow
+	tokenPipe
+	tokenSemicolon
+	tokenLeftParen
+	tokenRightParen
+	tokenQuestion
+	tokenStar
+	tokenPlus
+)
+
+type token struct {
+	type_ tokenType
+	text  string
+	line  int
+}
+
+func isNameChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_'
+}
+
+func scan(source string) ([]token, error) {
+	var tokens []token
+	line := 1
+
+	for current := 0; current < len(source); {
+		c := source[current]
+		start := current
+		current += 1
+
+		switch c {
+		case '\n':
+			line += 1
+		case ' ', '\t', '\r':
+		case '|':
+			tokens = append(tokens, token{tokenPipe, "|", line})
+		case ';':
+			tokens = append(tokens, token{tokenSemicolon, ";", line})
+		case '(':
+			tokens = append(tokens, token{tokenLeftParen, "(", line})
+		case ')':
+			tokens = append(tokens, token{tokenRightParen, ")", line})
+		case '?':
+			tokens = append(tokens, token{tokenQuestion, "?", line})
+		case '*':
+			tokens = append(tokens, token{tokenStar, "*", line})
+		case '+':
+			tokens = append(tokens, token{tokenPlus, "+", line})
+		case '-':
+			if current >= len(source) || source[current] != '>' {
+				return nil, fmt.Errorf("line %d: expected '->'", line)
+			}
+			current += 1
+			tokens = append(tokens, token{tokenArrow, "->", line})
+		case '"':
+			end := strings.IndexAny(source[current:], "\"\n")
+			if end <= 0 || source[current+end] != '"' {
+				return nil, fmt.Errorf("line %d: unterminated or empty literal", line)
+			}
+			tokens = append(tokens, token{tokenLiteral, source[current : current+end], line})
+			current += end + 1
+		default:
+			if !isNameChar(c) {
+				return nil, fmt.Errorf("line %d: unexpected character '%c'", line, c)
+			}
+			for current < len(source) && isNameChar(source[current]) {
+				current += 1
+			}
+			tokens = append(tokens, token{tokenName, source[start:current], line})
+		}
+	}
+
+	return tokens, nil
+}
+
+type parser struct {
+	tokens  []token
+	current int
+}
+
+func (p *parser) isAtEnd() bool {
+	return p.current >= len(p.tokens)
+}
+
+func (p *parser) check(type_ tokenType) bool {
+	return !p.isAtEnd() && p.tokens[p.current].type_ == type_
+}
+
+func (p *parser) consume(type_ tokenType, expected string) (token, error) {
+	if !p.check(type_) {
+		if p.isAtEnd() {
+			return token{}, fmt.Errorf("expected %s at end of grammar", expected)
+		}
+		t := p.tokens[p.current]
+		return token{}, fmt.Errorf("line %d: expected %s, got '%s'", t.line, expected, t.text)
+	}
+	p.current += 1
+	return p.tokens[p.current-1], nil
+}
+
+// choice   -> sequence ( "|" sequence )* ;
+func (p *parser) parseChoice() (Node, error) {
+	var alternatives []Node
+	for {
+		sequence, err := p.parseSequence()
+		if err != nil {
+			return nil, err
+		}
+		alternatives = append(alternatives, sequence)
+
+		if !p.check(tokenPipe) {
+			break
+		}
+		p.current += 1
+	}
+
+	if len(alternatives) == 1 {
+		return alternatives[0], nil
+	}
+	return Choice{Alternatives: alternatives}, nil
+}
+
+// sequence -> item* ;
+func (p *parser) parseSequence() (Node, error) {
+	var items []Node
+	for !p.isAtEnd() && !p.check(tokenPipe) && !p.check(tokenSemicolon) && !p.check(tokenRightParen) {
+		item, err := p.parseItem()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	if len(items) == 1 {
+		return items[0], nil
+	}
+	return Sequence{Items: items}, nil
+}
+
+// item     -> ( LITERAL | NAME | "(" choice ")" ) ( "?" | "*" | "+" )? ;
+func (p *parser) parseItem() (Node, error) {
+	var item Node
+
+	switch {
+	case p.check(tokenLiteral):
+		item = Literal{Text: p.tokens[p.current].text}
+		p.current += 1
+	case p.check(tokenName):
+		item = Reference{Name: p.tokens[p.current].text}
+		p.current += 1
+	case p.check(tokenLeftParen):
+		p.current += 1
+		var err error
+		item, err = p.parseChoice()
+		if err != nil {
+			return nil, err
+		}
+		_, err = p.consume(tokenRightParen, "')' after group")
+		if err != nil {
+			return nil, err
+		}
+	default:
+		t := p.tokens[p.current]
+		return nil, fmt.Errorf("line %d: unexpected '%s'", t.line, t.text)
+	}
+
+	switch {
+	case p.check(tokenQuestion):
+		item = Repeat{Item: item, Min: 0, Max: 1}
+	case p.check(tokenStar):
+		item = Repeat{Item: item, Min: 0, Max: Unbounded}
+	case p.check(tokenPlus):
+		item = Repeat{Item: item, Min: 1, Max: Unbounded}
+	default:
+		return item, nil
+	}
+	p.current += 1
+	return item, nil
+}
@@ -0,0 +1,192 @@
+package grammar
+
+import (
+	"math/rand/v2"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"toterich/golox/ast"
+	"toterich/golox/parse"
+)
+
+func TestParse(t *testing.T) {
+	grammar, err := Parse(`
+		list -> "(" ( item ( "," item )* )? ")" ;
+		item -> NUMBER | list ;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]Node{
+		"list": Sequence{Items: []Node{
+			Literal{Text: "("},
+			Repeat{Item: Sequence{Items: []Node{
+				Reference{Name: "item"},
+				Repeat{Item: Sequence{Items: []Node{Literal{Text: ","}, Reference{Name: "item"}}}, Min: 0, Max: Unbounded},
+			}}, Min: 0, Max: 1},
+			Literal{Text: ")"},
+		}},
+		"item": Choice{Alternatives: []Node{Reference{Name: "NUMBER"}, Reference{Name: "list"}}},
+	}
+	if grammar.Start != "list" {
+		t.Errorf("expected start rule list, got %s", grammar.Start)
+	}
+	if !reflect.DeepEqual(grammar.Rules, expected) {
+		t.Errorf("expected rules %v, got %v", expected, grammar.Rules)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		`a -> "x"`:                  "expected ';' after rule at end of grammar",
+		`a -> b ;`:                  "rule a refers to undefined rule b",
+		`a -> "x" a ;`:              "rule a can't derive any text without referring to itself",
+		`a -> "x" ; a -> "y" ;`:     "line 1: rule a is defined more than once",
+		`A -> "x" ;`:                "line 1: A is the name of a terminal and can't be defined",
+		"a -> \"x ;":                "line 1: unterminated or empty literal",
+		"a -> \"x\" ;\nb = \"y\" ;": "line 2: unexpected character '='",
+		"":                          "grammar has no rules",
+	}
+
+	for source, expected := range tests {
+		_, err := Parse(source)
+		if err == nil || err.Error() != expected {
+			t.Errorf("%q: expected error %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestGenerateMaxDepth(t *testing.T) {
+	grammar, err := Parse(`list -> "(" list* ")" ;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	generator := NewGenerator(grammar, 1)
+	generator.MaxDepth = 5
+	generator.Layout = func(tokens []string) string { return strings.Join(tokens, "") }
+
+	for range 100 {
+		program, err := generator.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		nesting := 0
+		for _, c := range program {
+			if c == '(' {
+				nesting += 1
+				if nesting > generator.MaxDepth {
+					t.Fatalf("%s is nested deeper than %d", program, generator.MaxDepth)
+				}
+			} else {
+				nesting -= 1
+			}
+		}
+	}
+}
+
+func TestGenerateMissingTerminal(t *testing.T) {
+	grammar, err := Parse(`number -> "-"? NUMBER ;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewGenerator(grammar, 1).Generate()
+	if err == nil || err.Error() != "no text is generated for terminal NUMBER" {
+		t.Errorf("expected error for missing terminal, got %v", err)
+	}
+}
+
+func loadLoxGrammar(t *testing.T) *Grammar {
+	data, err := os.ReadFile("../../lox_spec/grammar.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grammar, err := Parse(string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return grammar
+}
+
+func TestGenerateIsDeterministic(t *testing.T) {
+	grammar := loadLoxGrammar(t)
+	first, second := NewLoxGenerator(grammar, 42), NewLoxGenerator(grammar, 42)
+
+	for range 10 {
+		a, err := first.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := second.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a != b {
+			t.Fatalf("generators with the same seed differ:\n%s\n---\n%s", a, b)
+		}
+	}
+}
+
+// Records which rules have been expanded
+type coverage struct {
+	Constraints
+	expanded map[string]bool
+}
+
+func (c *coverage) Accept(rule string, text string, enclosing []Frame) bool {
+	accepted := c.Constraints.Accept(rule, text, enclosing)
+	if accepted {
+		c.expanded[rule] = true
+	}
+	return accepted
+}
+
+// The parser needs to accept every program the grammar allows
+func TestParserAcceptsGeneratedPrograms(t *testing.T) {
+	grammar := loadLoxGrammar(t)
+	covered := map[string]bool{}
+
+	for seed := range uint64(200) {
+		generator := NewLoxGenerator(grammar, seed)
+		generator.Constraints = &coverage{Constraints: generator.Constraints, expanded: covered}
+
+		for range 5 {
+			program, err := generator.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			scanner := parse.NewScanner(ast.NewStringTable())
+			tokens, errs := scanner.ScanTokens(program)
+			if errs == nil {
+				var parser parse.Parser
+				_, errs = parser.Parse(tokens)
+			}
+			if errs != nil {
+				t.Fatalf("seed %d: %v\n%s", seed, errs, program)
+			}
+		}
+	}
+
+	for rule := range grammar.Rules {
+		if !covered[rule] {
+			t.Errorf("rule %s has never been generated", rule)
+		}
+	}
+}
+
+func TestLoxNumbersScan(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 1))
+	for range 1000 {
+		number := loxNumber(r)
+		scanner := parse.NewScanner(ast.NewStringTable())
+		tokens, errs := scanner.ScanTokens(number)
+		if errs != nil || len(tokens) != 2 || tokens[0].Type != ast.NUMBER {
+			t.Fatalf("%s doesn't scan as a single number: %v", number, errs)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package grammar
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"toterich/golox/ast"
+	"toterich/golox/parse"
+)
+
+// Few names make it likely that generated programs use the variables and functions they declare
+var loxIdentifiers = []string{"a", "b", "c", "f", "n", "x"}
+
+// Expressions nest about as many rules deep as there are precedence levels, each of which may repeat its operator.
+// Repeating them as often as statements would make most programs consist of a few huge expressions.
+var loxOperatorRules = map[string]bool{
+	"comma_op": true, "conditional": true, "logic_or": true, "logic_and": true, "equality": true, "comparison": true,
+	"bit_or": true, "bit_xor": true, "bit_and": true, "shift": true, "term": true, "factor": true, "power": true,
+	"call": true, "arguments": true,
+}
+
+// Creates a Generator for the Lox grammar in lox_spec/grammar.txt, whose programs are accepted by the parser
+func NewLoxGenerator(grammar *Grammar, seed uint64) *Generator {
+	generator := NewGenerator(grammar, seed)
+	generator.Terminals = map[string]func(r *rand.Rand) string{
+		"IDENTIFIER": func(r *rand.Rand) string { return loxIdentifiers[r.IntN(len(loxIdentifiers))] },
+		"NUMBER":     loxNumber,
+		"STRING":     loxString,
+		"EOF":        func(r *rand.Rand) string { return "" },
+	}
+	generator.RepeatProbability = func(rule string, depth int) float64 {
+		switch {
+		case rule == "program":
+			return 0.9
+		case loxOperatorRules[rule]:
+			return generator.remainingDepth(depth) / 8
+		default:
+			return generator.remainingDepth(depth) * 0.6
+		}
+	}
+	generator.Constraints = &loxConstraints{caseValues: map[int][]ast.LoxValue{}}
+	generator.Layout = loxLayout
+	return generator
+}
+
+// Generates number literals in all notations the scanner supports
+func loxNumber(r *rand.Rand) string {
+	switch r.IntN(7) {
+	case 0:
+		return fmt.Sprintf("%d", r.IntN(10))
+	case 1:
+		return fmt.Sprintf("%d", r.IntN(1000))
+	case 2:
+		return fmt.Sprintf("%d.%d", r.IntN(100), r.IntN(100))
+	case 3:
+		return fmt.Sprintf("%de%d", 1+r.IntN(9), r.IntN(10))
+	case 4:
+		return fmt.Sprintf("%d_%03d", 1+r.IntN(100), r.IntN(1000))
+	case 5:
+		return fmt.Sprintf("0x%X", r.IntN(256))
+	default:
+		return fmt.Sprintf("0b%b", r.IntN(16))
+	}
+}
+
+func loxString(r *rand.Rand) string {
+	const chars = "abcxyz "
+	var text strings.Builder
+	for range r.IntN(6) {
+		text.WriteByte(chars[r.IntN(len(chars))])
+	}
+	return `"` + text.String() + `"`
+}
+
+// The restrictions the parser places on programs beyond the grammar
+type loxConstraints struct {
+	caseValues map[int][]ast.LoxValue // The values of the cases generated so far, by the ID of their switch
+	strings    *ast.StringTable
+}
+
+// Returns the innermost of the enclosing rules that is one of the given rules, or false if there is none
+func innermost(enclosing []Frame, rules ...string) (Frame, bool) {
+	for idx := len(enclosing) - 1; idx >= 0; idx-- {
+		for _, rule := range rules {
+			if enclosing[idx].Rule == rule {
+				return enclosing[idx], true
+			}
+		}
+	}
+	return Frame{}, false
+}
+
+func (c *loxConstraints) Allow(rule string, enclosing []Frame) bool {
+	// Function bodies can't break out of or continue the loops and switches around them
+	switch rule {
+	case "breakStmt":
+		frame, ok := innermost(enclosing, "whileStmt", "forStmt", "switchStmt", "functionBody")
+		return ok && frame.Rule != "functionBody"
+	case "continueStmt":
+		frame, ok := innermost(enclosing, "whileStmt", "forStmt", "functionBody")
+		return ok && frame.Rule != "functionBody"
+	case "returnStmt":
+		_, ok := innermost(enclosing, "functionBody")
+		return ok
+	}
+	return true
+}
+
+func (c *loxConstraints) Accept(rule string, text string, enclosing []Frame) bool {
+	if rule != "literal" {
+		return true
+	}
+
+	// All case values of a switch need to be distinct, which the parser compares by value, e.g. 1 == 0x1
+	switch_, ok := innermost(enclosing, "switchStmt")
+	if !ok {
+		return true
+	}
+	value := c.caseValue(text)
+	for _, other := range c.caseValues[switch_.ID] {
+		if other.IsEqual(value) {
+			return false
+		}
+	}
+	c.caseValues[switch_.ID] = append(c.caseValues[switch_.ID], value)
+	return true
+}
+
+// Returns the value of a case literal, which is a single token or a negative number
+func (c *loxConstraints) caseValue(text string) ast.LoxValue {
+	if c.strings == nil {
+		c.strings = ast.NewStringTable()
+	}
+	scanner := parse.NewScanner(c.strings)
+	tokens, _ := scanner.ScanTokens(text)
+
+	if tokens[0].Type == ast.MINUS {
+		return ast.NewNumberValue(-tokens[1].Literal.AsNumber())
+	}
+	if tokens[0].Type == ast.NIL {
+		return ast.NewNilValue()
+	}
+	return tokens[0].Literal
+}
+
+// Tokens that continue the line after a closing brace
+var loxContinuations = map[string]bool{
+	";": true, ",": true, ")": true, ".": true, "(": true, "else": true, "catch": true, "finally": true,
+}
+
+// Puts each statement on its own line and indents blocks
+func loxLayout(tokens []string) string {
+	var program strings.Builder
+	indent := 0
+	parens := 0 // Inside parentheses, ';' doesn't end a statement, e.g. in the header of a for loop
+	var outerParens []int
+	lineStart := true
+	afterBrace := false
+
+	for _, token := range tokens {
+		// Closing braces inside parentheses end function expressions, which continue the line
+		if afterBrace && parens == 0 && !loxContinuations[token] {
+			program.WriteString("\n")
+			lineStart = true
+		}
+		afterBrace = false
+
+		switch token {
+		case "(":
+			parens += 1
+		case ")":
+			parens -= 1
+		case "}":
+			indent -= 1
+			parens = outerParens[len(outerParens)-1]
+			outerParens = outerParens[:len(outerParens)-1]
+			if !lineStart {
+				program.WriteString("\n")
+				lineStart = true
+			}
+		}
+
+		if lineStart {
+			program.WriteString(strings.Repeat("  ", indent))
+		} else {
+			program.WriteString(" ")
+		}
+		program.WriteString(token)
+		lineStart = false
+
+		switch token {
+		case "{":
+			// Function expressions start blocks inside of parentheses too
+			indent += 1
+			outerParens = append(outerParens, parens)
+			parens = 0
+			program.WriteString("\n")
+			lineStart = true
+		case "}":
+			afterBrace = true
+		case ";":
+			if parens == 0 {
+				program.WriteString("\n")
+				lineStart = true
+			}
+		}
+	}
+
+	if !lineStart {
+		program.WriteString("\n")
+	}
+	return program.String()
+}
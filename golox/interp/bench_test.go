@@ -0,0 +1,61 @@
+package interp
+
+import (
+	"testing"
+	"toterich/golox/ast"
+	"toterich/golox/parse"
+)
+
+const fibSource = `
+fun fib(n) {
+  if (n < 2) return n;
+  return fib(n - 1) + fib(n - 2);
+}
+fib(20);
+`
+
+const loopSource = `
+var sum = 0;
+for (var i = 0; i < 100000; i++) {
+  sum = sum + i * 2;
+}
+`
+
+func mustParse(tb testing.TB, strings *ast.StringTable, source string) *ast.Ast {
+	scanner := parse.NewScanner(strings)
+	var parser parse.Parser
+
+	tokens, errs := scanner.ScanTokens(source)
+	if errs != nil {
+		tb.Fatal(errs)
+	}
+	tree, errs := parser.Parse(tokens)
+	if errs != nil {
+		tb.Fatal(errs)
+	}
+	return tree
+}
+
+func benchmarkProgram(b *testing.B, source string) {
+	interpreter := NewInterpreter()
+	tree := mustParse(b, interpreter.Strings(), source)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		for _, stmt := range tree.Body {
+			err := interpreter.Execute(tree, stmt)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkFib(b *testing.B) {
+	benchmarkProgram(b, fibSource)
+}
+
+func BenchmarkLoop(b *testing.B) {
+	benchmarkProgram(b, loopSource)
+}
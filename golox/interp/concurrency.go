@@ -0,0 +1,325 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"toterich/golox/ast"
+	"toterich/golox/util"
+)
+
+// Tracks the tasks spawned by a program, including those spawned by other tasks. The operations that block
+// (await, sending to and receiving from channels, and Wait()) wait while holding mu, which makes it possible
+// to detect a deadlock: if every goroutine of the program, including the one the interpreter runs on, is
+// waiting, none of them can ever be woken.
+type taskGroup struct {
+	mu       sync.Mutex
+	live     int                       // Number of tasks that haven't finished yet
+	runnable int                       // Number of goroutines that aren't waiting, including the interpreter's own
+	waiting  int                       // Number of goroutines waiting for changed to be closed
+	changed  chan struct{}             // Closed and replaced whenever a task finishes or a channel changes
+	deadlock chan struct{}             // Closed and replaced when a deadlock is detected
+	failed   map[*ast.LoxTask]struct{} // Tasks that finished with an error which hasn't been awaited yet
+}
+
+// The cause of the RuntimeError returned by an operation that would block forever, because all tasks of the
+// program are blocked
+var ErrDeadlock = errors.New("deadlock, all tasks are blocked.")
+
+func newTaskGroup() *taskGroup {
+	return &taskGroup{
+		runnable: 1,
+		changed:  make(chan struct{}),
+		deadlock: make(chan struct{}),
+		failed:   map[*ast.LoxTask]struct{}{},
+	}
+}
+
+// Wakes all waiting goroutines, so they check whether they can continue. They are counted as runnable right
+// away, so that no deadlock is detected before they had a chance to run. Must be called with mu held.
+func (g *taskGroup) wakeAll() {
+	g.runnable += g.waiting
+	g.waiting = 0
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
+
+// Waits until another goroutine calls wakeAll(). Must be called with mu held, which is released while waiting.
+// Returns errNativeInterrupted if done is closed first, and ErrDeadlock if no goroutine is left to wake the
+// waiting ones, which are all woken with ErrDeadlock.
+func (g *taskGroup) wait(done <-chan struct{}) error {
+	g.runnable -= 1
+	g.waiting += 1
+	if g.runnable == 0 {
+		g.runnable, g.waiting = g.waiting, 0
+		close(g.deadlock)
+		g.deadlock = make(chan struct{})
+		return ErrDeadlock
+	}
+
+	changed, deadlock := g.changed, g.deadlock
+	g.mu.Unlock()
+	var err error
+	select {
+	case <-changed:
+	case <-deadlock:
+		err = ErrDeadlock
+	case <-done:
+		err = errNativeInterrupted
+	}
+	g.mu.Lock()
+
+	if err == errNativeInterrupted {
+		// Unless this goroutine has been woken in the meantime, it still counts as waiting
+		select {
+		case <-changed:
+		case <-deadlock:
+		default:
+			g.runnable += 1
+			g.waiting -= 1
+		}
+	}
+	return err
+}
+
+// Returns the error to fail a blocking operation at location with, after wait() returned err
+func (i *Interpreter) waitError(location ast.Token, err error) error {
+	if errors.Is(err, ErrDeadlock) {
+		return util.NewRuntimeErrorWithCause(location, ErrDeadlock)
+	}
+	return i.checkInterrupted(location)
+}
+
+// Waits until all tasks spawned so far have finished. Returns the errors of those that failed without being
+// awaited, which would otherwise go unnoticed. If the interpreter's context is done first, only the
+// interruption is returned, the tasks stop on their own.
+func (i *Interpreter) Wait() []error {
+	i.tasks.mu.Lock()
+	defer i.tasks.mu.Unlock()
+
+	for i.tasks.live > 0 {
+		// After a deadlock, the blocked tasks fail and finish
+		if err := i.tasks.wait(i.done); errors.Is(err, errNativeInterrupted) {
+			return []error{i.checkInterrupted(ast.Token{})}
+		}
+	}
+
+	var errs []error
+	for task := range i.tasks.failed {
+		errs = append(errs, task.Err)
+	}
+	clear(i.tasks.failed)
+	return errs
+}
+
+// Starts the call on a new goroutine and returns a task handle for its result
+func (i *Interpreter) evalSpawn(expr *ast.SpawnExpr) (ast.LoxValue, error) {
+	call := i.tree.Expr(expr.Call).(*ast.CallExpr)
+	fun, err := i.evalCallee(call)
+	if err != nil {
+		return ast.NewNilValue(), err
+	}
+
+	var args []ast.LoxValue
+	for _, arg := range call.Arguments {
+		arg, err := i.Evaluate(arg)
+		if err != nil {
+			return arg, err
+		}
+		args = append(args, arg)
+	}
+
+	err = checkArity(fun, args, call.Location)
+	if err != nil {
+		return ast.NewNilValue(), err
+	}
+
+	task := &ast.LoxTask{Done: make(chan struct{})}
+	child := i.fork()
+
+	i.tasks.mu.Lock()
+	i.tasks.live += 1
+	i.tasks.runnable += 1
+	i.tasks.mu.Unlock()
+	go func() {
+		result, err := child.call(fun, args, call.Location)
+
+		i.tasks.mu.Lock()
+		defer i.tasks.mu.Unlock()
+		task.Result, task.Err = result, err
+		if task.Err != nil {
+			i.tasks.failed[task] = struct{}{}
+		}
+		close(task.Done)
+		i.tasks.live -= 1
+		i.tasks.runnable -= 1
+		i.tasks.wakeAll()
+	}()
+
+	return ast.NewTaskValue(task), nil
+}
+
+// Waits for a task to finish and returns its result, or the error it failed with
+func (i *Interpreter) evalAwait(expr *ast.AwaitExpr) (ast.LoxValue, error) {
+	value, err := i.Evaluate(expr.Task)
+	if err != nil {
+		return value, err
+	}
+
+	if value.Type != ast.LT_TASK {
+		return ast.NewNilValue(), util.NewRuntimeError(expr.Keyword, fmt.Sprintf("operand of 'await' is a %s, not a Task.", value.Type))
+	}
+	task := value.AsTask()
+
+	i.tasks.mu.Lock()
+	defer i.tasks.mu.Unlock()
+	for !finished(task) {
+		if err := i.tasks.wait(i.done); err != nil {
+			return ast.NewNilValue(), i.waitError(expr.Keyword, err)
+		}
+	}
+
+	if task.Err != nil {
+		delete(i.tasks.failed, task)
+	}
+	return task.Result, detachTrace(task.Err)
+}
+
+func finished(task *ast.LoxTask) bool {
+	select {
+	case <-task.Done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Returned by native functions when they stop waiting because the program is interrupted
+var errNativeInterrupted = errors.New("interrupted")
+
+func (i *Interpreter) callNative(callee *ast.LoxFunction, arguments []ast.LoxValue, location ast.Token) (ast.LoxValue, error) {
+	// The arguments may live on the caller's stack, so natives get a copy they are free to keep
+	value, err := callee.Native(slices.Clone(arguments), i.done)
+	if errors.Is(err, errNativeInterrupted) || errors.Is(err, ErrDeadlock) {
+		return ast.NewNilValue(), i.waitError(location, err)
+	}
+	if err != nil {
+		return ast.NewNilValue(), util.NewRuntimeError(location, err.Error())
+	}
+	return value, nil
+}
+
+// Creates a native function with the given parameter names
+func newNative(name string, params []string, native ast.NativeFunction) *ast.LoxFunction {
+	fun := &ast.LoxFunction{Name: name, Native: native}
+	for _, param := range params {
+		fun.Params = append(fun.Params, ast.Token{Type: ast.IDENTIFIER, Lexeme: param})
+	}
+	return fun
+}
+
+// The largest buffer a channel can be created with
+const maxChannelCapacity = 1 << 16
+
+// channel(capacity) creates a channel which can buffer up to capacity values. Sending to a channel without
+// free buffer space blocks until another task receives from it.
+var channelFunction = newNative("channel", []string{"capacity"},
+	func(args []ast.LoxValue, interrupted <-chan struct{}) (ast.LoxValue, error) {
+		capacity := args[0]
+		if capacity.Type != ast.LT_NUMBER || capacity.AsNumber() != math.Trunc(capacity.AsNumber()) ||
+			capacity.AsNumber() < 0 || capacity.AsNumber() > maxChannelCapacity {
+			return ast.NewNilValue(), fmt.Errorf("channel capacity must be an integer between 0 and %d, got %s.", maxChannelCapacity, capacity)
+		}
+
+		channel := &ast.LoxChannel{Capacity: int(capacity.AsNumber())}
+		return ast.NewChannelValue(channel), nil
+	})
+
+// Returns the method of the given name bound to a channel:
+// send(value) blocks until the value is received, or until it fits into the channel's buffer. It is an error to
+// send to a closed channel.
+// receive() blocks until a value can be received. Once the channel is closed and empty, it returns nil.
+// close() closes the channel, so no more values can be sent to it.
+// Channels are guarded by the lock of the program's tasks, so that blocked tasks are woken by the operations
+// of others through wakeAll().
+func (i *Interpreter) channelMethod(channel *ast.LoxChannel, name ast.Token) (ast.LoxValue, error) {
+	var method *ast.LoxFunction
+	tasks := i.tasks
+
+	switch name.Symbol {
+	case i.symbols.send:
+		method = newNative("send", []string{"value"}, func(args []ast.LoxValue, interrupted <-chan struct{}) (ast.LoxValue, error) {
+			return ast.NewNilValue(), tasks.send(channel, args[0], interrupted)
+		})
+	case i.symbols.receive:
+		method = newNative("receive", nil, func(args []ast.LoxValue, interrupted <-chan struct{}) (ast.LoxValue, error) {
+			return tasks.receive(channel, interrupted)
+		})
+	case i.symbols.close:
+		method = newNative("close", nil, func(args []ast.LoxValue, interrupted <-chan struct{}) (ast.LoxValue, error) {
+			return ast.NewNilValue(), tasks.close(channel)
+		})
+	default:
+		return ast.NewNilValue(), util.NewRuntimeError(name, fmt.Sprintf("undefined property '%s'.", name.Lexeme))
+	}
+
+	return ast.NewFunction(method), nil
+}
+
+// A value whose send fails because the program is interrupted or deadlocked stays in the channel
+func (g *taskGroup) send(channel *ast.LoxChannel, value ast.LoxValue, interrupted <-chan struct{}) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if channel.Closed {
+		return errors.New("send to closed channel.")
+	}
+	channel.Buffer = append(channel.Buffer, value)
+	g.wakeAll()
+
+	// The send completes once at most Capacity values sent before and including this one haven't been received
+	position := channel.Received + len(channel.Buffer)
+	for position-channel.Received > channel.Capacity {
+		if err := g.wait(interrupted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *taskGroup) receive(channel *ast.LoxChannel, interrupted <-chan struct{}) (ast.LoxValue, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for len(channel.Buffer) == 0 {
+		if channel.Closed {
+			return ast.NewNilValue(), nil
+		}
+		if err := g.wait(interrupted); err != nil {
+			return ast.NewNilValue(), err
+		}
+	}
+
+	value := channel.Buffer[0]
+	channel.Buffer[0] = ast.LoxValue{}
+	channel.Buffer = channel.Buffer[1:]
+	channel.Received += 1
+	// Senders may be waiting for their value to be received
+	g.wakeAll()
+	return value, nil
+}
+
+func (g *taskGroup) close(channel *ast.LoxChannel) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if channel.Closed {
+		return errors.New("channel is already closed.")
+	}
+	channel.Closed = true
+	// Receivers waiting for values get nil now
+	g.wakeAll()
+	return nil
+}
@@ -0,0 +1,259 @@
+package interp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"toterich/golox/util"
+)
+
+// Runs a program whose assertions are throw statements and returns the first error
+func runProgram(t *testing.T, ctx context.Context, source string) error {
+	t.Helper()
+
+	interpreter := NewInterpreter()
+	tree := mustParse(t, interpreter.Strings(), source)
+
+	for _, stmt := range tree.Body {
+		err := interpreter.ExecuteContext(ctx, tree, stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	errs := interpreter.Wait()
+	if errs != nil {
+		return errs[0]
+	}
+	return nil
+}
+
+func TestSpawnAwait(t *testing.T) {
+	err := runProgram(t, context.Background(), `
+		fun square(x) { return x * x; }
+		fun sum(n) {
+			var t1 = spawn square(n);
+			var t2 = spawn square(n + 1);
+			return await t1 + await t2;
+		}
+		var a = spawn sum(1);
+		var b = spawn sum(2);
+		if (await a + await b != 18) throw "wrong total";
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSharedGlobals(t *testing.T) {
+	err := runProgram(t, context.Background(), `
+		var counter = 0;
+		var names = "";
+		fun work(id) {
+			for (var i = 0; i < 100; i++) {
+				counter++;
+				names = names + "x";
+			}
+			return id;
+		}
+		var tasks = channel(8);
+		for (var i = 0; i < 8; i++) {
+			tasks.send(spawn work(i));
+		}
+		tasks.close();
+		var sum = 0;
+		var task = tasks.receive();
+		while (task != nil) {
+			sum += await task;
+			task = tasks.receive();
+		}
+		if (sum != 28) throw "wrong sum";
+		// Increments of shared globals are not atomic, so only a lower bound can be checked
+		if (counter < 100) throw "too few increments";
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChannelFanIn(t *testing.T) {
+	err := runProgram(t, context.Background(), `
+		fun produce(ch, from, to) {
+			for (var i = from; i < to; i++) ch.send(i);
+		}
+		var ch = channel(0);
+		var producers = channel(4);
+		for (var p = 0; p < 4; p++) producers.send(spawn produce(ch, p * 10, p * 10 + 10));
+		var sum = 0;
+		for (var n = 0; n < 40; n++) sum += ch.receive();
+		for (var p = 0; p < 4; p++) await producers.receive();
+		if (sum != 780) throw "wrong sum";
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAwaitError(t *testing.T) {
+	err := runProgram(t, context.Background(), `
+		fun fail() { return 1 / nil; }
+		var task = spawn fail();
+		var caught = false;
+		try {
+			await task;
+		} catch (e) {
+			caught = true;
+		}
+		if (!caught) throw "error not raised by await";
+		// Awaiting a failed task twice raises its error again
+		try { await task; } catch (e) { caught = false; }
+		if (caught) throw "error not raised by second await";
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnawaitedError(t *testing.T) {
+	err := runProgram(t, context.Background(), `
+		fun fail() { throw "lost"; }
+		spawn fail();
+	`)
+
+	var exception util.LoxException
+	if !errors.As(err, &exception) || exception.Value.String() != "lost" {
+		t.Fatalf("expected exception of unawaited task, got %v", err)
+	}
+}
+
+func TestChannelErrors(t *testing.T) {
+	err := runProgram(t, context.Background(), `
+		var ch = channel(1);
+		ch.close();
+		if (ch.receive() != nil) throw "receive from closed channel";
+		var caught = 0;
+		try { ch.send(1); } catch (e) { caught++; }
+		try { ch.close(); } catch (e) { caught++; }
+		try { channel(-1); } catch (e) { caught++; }
+		if (caught != 3) throw "missing errors";
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInterruptBlockedTasks(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// The spinning task could still send to the channel, so the program isn't deadlocked
+	err := runProgram(t, ctx, `
+		fun block(ch) { ch.receive(); }
+		fun spin() { while (true) {} }
+		var ch = channel(0);
+		var task = spawn block(ch);
+		spawn spin();
+		await task;
+	`)
+	if !errors.Is(err, ErrInterrupted) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected interruption, got %v", err)
+	}
+}
+
+func TestStepLimitAcrossTasks(t *testing.T) {
+	interpreter := NewInterpreter()
+	interpreter.SetLimits(Limits{MaxSteps: 10_000})
+	tree := mustParse(t, interpreter.Strings(), `
+		fun spin() { while (true) {} }
+		var a = spawn spin();
+		var b = spawn spin();
+		await a;
+	`)
+
+	var err error
+	for _, stmt := range tree.Body {
+		err = interpreter.Execute(tree, stmt)
+		if err != nil {
+			break
+		}
+	}
+	interpreter.Wait()
+
+	if !errors.Is(err, ErrStepLimit) {
+		t.Fatalf("expected step limit error, got %v", err)
+	}
+}
+
+// Maps programs to the line their deadlock is reported at, or 0 if that depends on the scheduling of tasks
+func TestDeadlock(t *testing.T) {
+	tests := map[string]int{
+		// The main program waits for a task that waits for a value nobody sends
+		`var c = channel(0);
+		fun f() { return c.receive(); }
+		var t = spawn f();
+		await t;`: 4,
+		// Two tasks wait for each other
+		`var a = channel(0);
+		var b = channel(0);
+		fun f() { a.receive(); b.send(1); }
+		fun g() { b.receive(); a.send(1); }
+		spawn f();
+		spawn g();`: 0,
+		`var c = channel(1);
+		c.send(1);
+		c.send(2);`: 3,
+	}
+
+	for source, line := range tests {
+		err := runProgram(t, context.Background(), source)
+		var runtimeErr util.RuntimeError
+		if !errors.Is(err, ErrDeadlock) || !errors.As(err, &runtimeErr) {
+			t.Errorf("%s\nexpected deadlock, got %v", source, err)
+		} else if line != 0 && runtimeErr.Token.Line != line {
+			t.Errorf("%s\nexpected deadlock at line %d, got %v", source, line, err)
+		}
+	}
+}
+
+// Tasks that hand values back and forth are never all blocked at once, however they are scheduled
+func TestNoFalseDeadlock(t *testing.T) {
+	err := runProgram(t, context.Background(), `
+		var ping = channel(0);
+		var pong = channel(0);
+		fun player(in, out, rounds) {
+			for (var i = 0; i < rounds; i++) {
+				out.send(in.receive() + 1);
+			}
+		}
+		spawn player(ping, pong, 1000);
+		var task = spawn player(pong, ping, 999);
+		ping.send(0);
+		await task;
+		if (pong.receive() != 1999) throw "values were lost";
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitInterrupted(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	interpreter := NewInterpreter()
+	tree := mustParse(t, interpreter.Strings(), `
+		fun spin() { while (true) {} }
+		spawn spin();
+	`)
+	for _, stmt := range tree.Body {
+		if err := interpreter.ExecuteContext(ctx, tree, stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	errs := interpreter.Wait()
+	if len(errs) != 1 || !errors.Is(errs[0], ErrInterrupted) {
+		t.Fatalf("expected interruption, got %v", errs)
+	}
+}
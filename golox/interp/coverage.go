@@ -0,0 +1,383 @@
+package interp
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"sync/atomic"
+	"toterich/golox/ast"
+)
+
+// Records which statements of a program are executed and which way its branches go. The branches are the
+// conditions of if statements and loops, which are taken when they are true, and the right operands of and
+// and or, which are taken when they are evaluated instead of being short-circuited. Write a report with
+// WriteText(), WriteLCOV() or WriteHTML() once the program has finished.
+type Coverage struct {
+	file   string
+	source string
+
+	// All statements and branches of the program, registered up front and never modified afterwards, so that
+	// tasks can record their execution concurrently
+	statements    ast.SideTable[ast.StmtID, coveredStatement]
+	statementList []coveredStatement
+	stmtBranches  ast.SideTable[ast.StmtID, *branchPoint] // Of IfStmts and WhileStmts
+	exprBranches  ast.SideTable[ast.ExprID, *branchPoint] // Of AndExprs and OrExprs
+	branchList    []*branchPoint
+}
+
+type coveredStatement struct {
+	stmt  ast.Stmt
+	line  int
+	count *atomic.Int64
+}
+
+// A point at which execution takes one of two ways
+type branchPoint struct {
+	node     ast.Node
+	line     int    // Of the statement the branch is part of
+	kind     string // "if", "while", "and" or "or"
+	taken    atomic.Int64
+	notTaken atomic.Int64
+}
+
+// Creates a Coverage for the program compiled from source, which has been read from file. Pass it to the
+// interpreter that runs the program with SetCoverage().
+func NewCoverage(file string, source string, program *Program) *Coverage {
+	c := &Coverage{file: file, source: source}
+	for _, stmt := range program.tree.Body {
+		ast.Walk(program.tree, coverageVisitor{c: c}, program.tree.Stmt(stmt))
+	}
+	return c
+}
+
+// Makes the interpreter record which statements and branches it executes in coverage, or stops recording if
+// coverage is nil. Tasks spawned by the interpreter record into the same coverage.
+func (i *Interpreter) SetCoverage(coverage *Coverage) {
+	i.coverage = coverage
+}
+
+// Registers the statements and branches of a tree with its coverage
+type coverageVisitor struct {
+	c    *Coverage
+	line int // Of the innermost statement, which branches of expressions are reported at
+}
+
+func (v coverageVisitor) Visit(node ast.Node) ast.Visitor {
+	if stmt, ok := node.(ast.Stmt); ok {
+		covered := coveredStatement{stmt: stmt, line: stmt.StartLine(), count: &atomic.Int64{}}
+		v.c.statements.Set(stmt.ID(), covered)
+		v.c.statementList = append(v.c.statementList, covered)
+		v.line = stmt.StartLine()
+	}
+
+	switch node := node.(type) {
+	case *ast.IfStmt:
+		v.c.addBranch(node, v.line, "if")
+	case *ast.WhileStmt:
+		v.c.addBranch(node, v.line, "while")
+	case *ast.AndExpr:
+		v.c.addBranch(node, v.line, "and")
+	case *ast.OrExpr:
+		v.c.addBranch(node, v.line, "or")
+	}
+	return v
+}
+
+func (c *Coverage) addBranch(node ast.Node, line int, kind string) {
+	branch := &branchPoint{node: node, line: line, kind: kind}
+	switch node := node.(type) {
+	case ast.Stmt:
+		c.stmtBranches.Set(node.ID(), branch)
+	case ast.Expr:
+		c.exprBranches.Set(node.ID(), branch)
+	}
+	c.branchList = append(c.branchList, branch)
+}
+
+// Records an execution of stmt. Statements that aren't part of the program are ignored, including those of
+// other programs whose IDs coincide with the program's.
+func (c *Coverage) statement(stmt ast.Stmt) {
+	if covered, ok := c.statements.Get(stmt.ID()); ok && covered.stmt == stmt {
+		covered.count.Add(1)
+	}
+}
+
+// Records whether the branch at node, an IfStmt, WhileStmt, AndExpr or OrExpr, has been taken
+func (c *Coverage) branch(node ast.Node, taken bool) {
+	var branch *branchPoint
+	switch node := node.(type) {
+	case ast.Stmt:
+		branch, _ = c.stmtBranches.Get(node.ID())
+	case ast.Expr:
+		branch, _ = c.exprBranches.Get(node.ID())
+	}
+	if branch == nil || branch.node != node {
+		return
+	}
+	if taken {
+		branch.taken.Add(1)
+	} else {
+		branch.notTaken.Add(1)
+	}
+}
+
+// Describes a way a branch has never gone
+func (b *branchPoint) missed() []string {
+	var missed []string
+	switch b.kind {
+	case "if", "while":
+		if b.taken.Load() == 0 {
+			missed = append(missed, b.kind+" condition was never true")
+		}
+		if b.notTaken.Load() == 0 {
+			missed = append(missed, b.kind+" condition was never false")
+		}
+	default:
+		if b.taken.Load() == 0 {
+			missed = append(missed, b.kind+" never evaluated its right operand")
+		}
+		if b.notTaken.Load() == 0 {
+			missed = append(missed, b.kind+" was never short-circuited")
+		}
+	}
+	return missed
+}
+
+// The coverage of a single source line
+type lineCoverage struct {
+	statements int
+	executed   int   // Number of statements that have been executed at least once
+	count      int64 // How often the most executed statement on the line has been executed
+	branches   []*branchPoint
+}
+
+// Returns the coverage of each line, indexed by line number
+func (c *Coverage) lines() []lineCoverage {
+	lines := make([]lineCoverage, strings.Count(c.source, "\n")+2)
+	for _, stmt := range c.statementList {
+		line := &lines[stmt.line]
+		count := stmt.count.Load()
+		line.statements += 1
+		if count > 0 {
+			line.executed += 1
+		}
+		line.count = max(line.count, count)
+	}
+	for _, branch := range c.branchList {
+		lines[branch.line].branches = append(lines[branch.line].branches, branch)
+	}
+	return lines
+}
+
+// Totals of a coverage report
+type coverageSummary struct {
+	statements, executedStatements int
+	branches, takenBranches        int // Each branch point has two branches
+}
+
+func (c *Coverage) summary() coverageSummary {
+	var s coverageSummary
+	for _, stmt := range c.statementList {
+		s.statements += 1
+		if stmt.count.Load() > 0 {
+			s.executedStatements += 1
+		}
+	}
+	for _, branch := range c.branchList {
+		s.branches += 2
+		if branch.taken.Load() > 0 {
+			s.takenBranches += 1
+		}
+		if branch.notTaken.Load() > 0 {
+			s.takenBranches += 1
+		}
+	}
+	return s
+}
+
+func percent(covered int, total int) string {
+	if total == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(covered)/float64(total))
+}
+
+// Writes a summary of the coverage, followed by the lines none of whose statements have been executed and the
+// branches that have only ever gone one way
+func (c *Coverage) WriteText(w io.Writer) error {
+	s := c.summary()
+	lines := c.lines()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Coverage of %s\n", c.file)
+	fmt.Fprintf(&b, "statements: %d/%d (%s)\n", s.executedStatements, s.statements, percent(s.executedStatements, s.statements))
+	fmt.Fprintf(&b, "branches:   %d/%d (%s)\n", s.takenBranches, s.branches, percent(s.takenBranches, s.branches))
+
+	// Consecutive lines are shown as a range, even if lines without statements are between them
+	var ranges []string
+	first, last := 0, 0
+	for number, line := range lines {
+		if line.statements == 0 {
+			continue
+		}
+		if line.executed == 0 {
+			if first == 0 {
+				first = number
+			}
+			last = number
+			continue
+		}
+		ranges = appendLineRange(ranges, first, last)
+		first = 0
+	}
+	ranges = appendLineRange(ranges, first, last)
+	if ranges != nil {
+		fmt.Fprintf(&b, "lines not executed: %s\n", strings.Join(ranges, ", "))
+	}
+
+	for number, line := range lines {
+		for _, branch := range line.branches {
+			// Branches that have never been reached are already reported as statements that weren't executed
+			if branch.taken.Load() == 0 && branch.notTaken.Load() == 0 {
+				continue
+			}
+			for _, missed := range branch.missed() {
+				fmt.Fprintf(&b, "line %d: %s\n", number, missed)
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func appendLineRange(ranges []string, first int, last int) []string {
+	switch {
+	case first == 0:
+		return ranges
+	case first == last:
+		return append(ranges, fmt.Sprint(first))
+	default:
+		return append(ranges, fmt.Sprintf("%d-%d", first, last))
+	}
+}
+
+// Writes the coverage as an LCOV tracefile, which tools like genhtml and most editors read
+func (c *Coverage) WriteLCOV(w io.Writer) error {
+	lines := c.lines()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "TN:\nSF:%s\n", c.file)
+
+	branches, takenBranches := 0, 0
+	for number, line := range lines {
+		for block, branch := range line.branches {
+			for idx, count := range []int64{branch.taken.Load(), branch.notTaken.Load()} {
+				branches += 1
+				switch {
+				case branch.taken.Load() == 0 && branch.notTaken.Load() == 0:
+					// The branch point itself has never been reached
+					fmt.Fprintf(&b, "BRDA:%d,%d,%d,-\n", number, block, idx)
+				default:
+					if count > 0 {
+						takenBranches += 1
+					}
+					fmt.Fprintf(&b, "BRDA:%d,%d,%d,%d\n", number, block, idx, count)
+				}
+			}
+		}
+	}
+	fmt.Fprintf(&b, "BRF:%d\nBRH:%d\n", branches, takenBranches)
+
+	found, hit := 0, 0
+	for number, line := range lines {
+		if line.statements == 0 {
+			continue
+		}
+		found += 1
+		if line.count > 0 {
+			hit += 1
+		}
+		fmt.Fprintf(&b, "DA:%d,%d\n", number, line.count)
+	}
+	fmt.Fprintf(&b, "LF:%d\nLH:%d\nend_of_record\n", found, hit)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// A line of the annotated source in the HTML report
+type htmlLine struct {
+	Number int
+	Count  string // Empty for lines without statements
+	Class  string
+	Note   string // The branches that have only gone one way
+	Text   string
+}
+
+var htmlReport = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Coverage of {{.File}}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; font-family: monospace; }
+td { padding: 0 0.5em; white-space: pre; }
+td.number, td.count { text-align: right; color: #777; }
+tr.covered td.text { background: #dfd; }
+tr.partial td.text { background: #ffd; }
+tr.uncovered td.text { background: #fdd; }
+</style>
+</head>
+<body>
+<h1>Coverage of {{.File}}</h1>
+<p>Statements: {{.Statements}}<br>Branches: {{.Branches}}</p>
+<table>
+{{range .Lines}}<tr class="{{.Class}}"{{if .Note}} title="{{.Note}}"{{end}}><td class="number">{{.Number}}</td><td class="count">{{.Count}}</td><td class="text">{{.Text}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// Writes a page showing the source with the number of times each line has been executed. Lines are
+// highlighted as covered, partially covered if some of their statements or branches haven't been executed,
+// or uncovered. Hovering over a partially covered line shows the branches that have been missed.
+func (c *Coverage) WriteHTML(w io.Writer) error {
+	s := c.summary()
+	lines := c.lines()
+
+	var htmlLines []htmlLine
+	for idx, text := range strings.Split(strings.TrimSuffix(c.source, "\n"), "\n") {
+		number := idx + 1
+		line := lines[number]
+		htmlLine := htmlLine{Number: number, Text: text}
+
+		if line.statements > 0 {
+			htmlLine.Count = fmt.Sprint(line.count)
+			var missed []string
+			for _, branch := range line.branches {
+				missed = append(missed, branch.missed()...)
+			}
+			htmlLine.Note = strings.Join(missed, "\n")
+
+			switch {
+			case line.executed == 0:
+				htmlLine.Class = "uncovered"
+			case line.executed < line.statements || missed != nil:
+				htmlLine.Class = "partial"
+			default:
+				htmlLine.Class = "covered"
+			}
+		}
+		htmlLines = append(htmlLines, htmlLine)
+	}
+
+	return htmlReport.Execute(w, map[string]any{
+		"File":       c.file,
+		"Statements": fmt.Sprintf("%d/%d (%s)", s.executedStatements, s.statements, percent(s.executedStatements, s.statements)),
+		"Branches":   fmt.Sprintf("%d/%d (%s)", s.takenBranches, s.branches, percent(s.takenBranches, s.branches)),
+		"Lines":      htmlLines,
+	})
+}
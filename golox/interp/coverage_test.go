@@ -0,0 +1,108 @@
+package interp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const coveredSource = `fun check(n) {
+  if (n > 0 and n < 10) return "digit";
+  return "other";
+}
+var i = 0;
+while (true) {
+  if (i == 3) break;
+  i++;
+}
+var task = spawn check(i);
+await task;
+if (false or i < 0) {
+  print "unreachable";
+}
+`
+
+func coverProgram(t *testing.T, source string) *Coverage {
+	t.Helper()
+
+	var coverage *Coverage
+	mustRunWith(t, source, func(program *Program, interpreter *Interpreter) {
+		coverage = NewCoverage("test.lox", source, program)
+		interpreter.SetCoverage(coverage)
+	})
+	return coverage
+}
+
+func TestCoverageText(t *testing.T) {
+	coverage := coverProgram(t, coveredSource)
+
+	var out bytes.Buffer
+	err := coverage.WriteText(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `Coverage of test.lox
+statements: 12/15 (80.0%)
+branches:   7/12 (58.3%)
+lines not executed: 3, 13
+line 2: if condition was never false
+line 2: and was never short-circuited
+line 6: while condition was never false
+line 12: if condition was never true
+line 12: or was never short-circuited
+`
+	if out.String() != expected {
+		t.Errorf("expected report\n%s\ngot\n%s", expected, out.String())
+	}
+}
+
+func TestCoverageLCOV(t *testing.T) {
+	coverage := coverProgram(t, coveredSource)
+
+	var out bytes.Buffer
+	err := coverage.WriteLCOV(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The spawned call of check() is recorded like the rest of the program. The loop is left by a break, so
+	// its condition is never false.
+	for _, expected := range []string{
+		"SF:test.lox\n",
+		"BRDA:2,0,0,1\nBRDA:2,0,1,0\nBRDA:2,1,0,1\nBRDA:2,1,1,0\n",
+		"BRDA:6,0,0,4\nBRDA:6,0,1,0\n",
+		"BRDA:7,0,0,1\nBRDA:7,0,1,3\n",
+		"BRDA:12,0,0,0\nBRDA:12,0,1,1\nBRDA:12,1,0,1\nBRDA:12,1,1,0\n",
+		"BRF:12\nBRH:7\n",
+		"DA:2,1\nDA:3,0\n",
+		"DA:6,4\nDA:7,4\nDA:8,3\n",
+		"DA:13,0\nLF:11\nLH:9\nend_of_record\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected tracefile to contain\n%s\ngot\n%s", expected, out.String())
+		}
+	}
+}
+
+func TestCoverageHTML(t *testing.T) {
+	coverage := coverProgram(t, coveredSource)
+
+	var out bytes.Buffer
+	err := coverage.WriteHTML(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"<tr class=\"partial\" title=\"if condition was never false\nand was never short-circuited\">",
+		`<td class="text">  if (n &gt; 0 and n &lt; 10) return &#34;digit&#34;;</td>`,
+		`<tr class="uncovered"><td class="number">3</td><td class="count">0</td>`,
+		`<tr class="covered"><td class="number">8</td><td class="count">3</td>`,
+		`<tr class=""><td class="number">14</td><td class="count"></td><td class="text">}</td></tr>`,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected page to contain\n%s\ngot\n%s", expected, out.String())
+		}
+	}
+}
@@ -1,12 +1,21 @@
 package interp
 
 import (
+	"sync"
 	"toterich/golox/ast"
 )
 
 type scope struct {
-	vars map[string]ast.LoxValue
-	root bool // If true, this scope only inherits from the global scope, not from any intermediate ones
+	vars    map[ast.Symbol]ast.LoxValue
+	root    bool // If true, this scope only inherits from the global scope, not from any intermediate ones
+	strings int  // Number of bytes of the strings stored in vars
+}
+
+// The global scope is shared by all tasks of a program, so unlike the other scopes, it is guarded by a lock
+type globalScope struct {
+	mu      sync.RWMutex
+	vars    map[ast.Symbol]ast.LoxValue
+	strings int
 }
 
 // Contains the current state of the interpreter.
@@ -17,18 +26,31 @@ type scope struct {
 // this identifier will return the value of the nested scope until that scope is popped. Then, accesses
 // to the identifier will return the value from the surrounding scope.
 type environment struct {
-	global scope
-	scopes []scope
+	global  *globalScope
+	scopes  []scope
+	vars    int // Number of variables declared in the local scopes, to approximate the memory used
+	strings int // Number of bytes of the strings stored in the local scopes
 }
 
+// Approximate memory used per variable and per scope, see size()
+const (
+	varSize   = 64
+	scopeSize = 64
+)
+
 func newEnvironment() environment {
 	// We always have at least the global scope
-	return environment{global: scope{vars: map[string]ast.LoxValue{}, root: true}}
+	return environment{global: &globalScope{vars: map[ast.Symbol]ast.LoxValue{}}}
+}
+
+// Returns a new environment for a spawned task, which shares the global scope with env
+func (env environment) fork() environment {
+	return environment{global: env.global}
 }
 
 // Query the value of an identifier, starting with the current scope and moving up the stack.
 // If the identifier does not exist in any scope, the second return parameter is false.
-func (env environment) getVar(ident string) (ast.LoxValue, bool) {
+func (env environment) getVar(ident ast.Symbol) (ast.LoxValue, bool) {
 	// Iterate backwards through the scopes so the most deeply nested ones are queried first
 	for i := len(env.scopes) - 1; i >= 0; i -= 1 {
 		val, ok := env.scopes[i].vars[ident]
@@ -41,7 +63,9 @@ func (env environment) getVar(ident string) (ast.LoxValue, bool) {
 		}
 	}
 
+	env.global.mu.RLock()
 	val, ok := env.global.vars[ident]
+	env.global.mu.RUnlock()
 	if ok {
 		return val, ok
 	}
@@ -50,21 +74,32 @@ func (env environment) getVar(ident string) (ast.LoxValue, bool) {
 }
 
 // Declare the given identifier in the current scope.
-func (env *environment) declareVal(ident string, value ast.LoxValue) {
-	if len(env.scopes) >= 1 {
-		env.scopes[len(env.scopes)-1].vars[ident] = value
-	} else {
+func (env *environment) declareVal(ident ast.Symbol, value ast.LoxValue) {
+	if len(env.scopes) == 0 {
+		env.global.mu.Lock()
+		env.global.strings += stringSize(value) - stringSize(env.global.vars[ident])
 		env.global.vars[ident] = value
+		env.global.mu.Unlock()
+		return
+	}
+
+	scope := &env.scopes[len(env.scopes)-1]
+	old, ok := scope.vars[ident]
+	if !ok {
+		env.vars += 1
 	}
+	env.addStrings(scope, stringSize(value)-stringSize(old))
+	scope.vars[ident] = value
 }
 
 // Set the value of an existing identifier, either in the current scope or in the nearest parent.
 // Returns true if the identifier has been previously declared in any scope, false otherwise
-func (env *environment) assignVal(ident string, value ast.LoxValue) bool {
+func (env *environment) assignVal(ident ast.Symbol, value ast.LoxValue) bool {
 	// Iterate backwards through the scopes so the most deeply nested ones are queried first
 	for i := len(env.scopes) - 1; i >= 0; i -= 1 {
-		_, ok := env.scopes[i].vars[ident]
+		old, ok := env.scopes[i].vars[ident]
 		if ok {
+			env.addStrings(&env.scopes[i], stringSize(value)-stringSize(old))
 			env.scopes[i].vars[ident] = value
 			return true
 		}
@@ -74,8 +109,11 @@ func (env *environment) assignVal(ident string, value ast.LoxValue) bool {
 		}
 	}
 
-	_, ok := env.global.vars[ident]
+	env.global.mu.Lock()
+	defer env.global.mu.Unlock()
+	old, ok := env.global.vars[ident]
 	if ok {
+		env.global.strings += stringSize(value) - stringSize(old)
 		env.global.vars[ident] = value
 		return true
 	}
@@ -85,10 +123,37 @@ func (env *environment) assignVal(ident string, value ast.LoxValue) bool {
 
 // Push a new scope
 func (env *environment) push(root bool) {
-	env.scopes = append(env.scopes, scope{vars: map[string]ast.LoxValue{}, root: root})
+	env.scopes = append(env.scopes, scope{vars: map[ast.Symbol]ast.LoxValue{}, root: root})
 }
 
 // Pop the most recent scope. All variables declared in this scope are discarded.
 func (env *environment) pop() {
+	env.vars -= len(env.scopes[len(env.scopes)-1].vars)
+	env.strings -= env.scopes[len(env.scopes)-1].strings
 	env.scopes = env.scopes[:len(env.scopes)-1]
 }
+
+// Accounts for a change in the size of the strings stored in a local scope
+func (env *environment) addStrings(scope *scope, bytes int) {
+	scope.strings += bytes
+	env.strings += bytes
+}
+
+// Returns the number of bytes of value if it is a string. Strings are counted once for every variable they
+// are stored in, even though variables share them.
+func stringSize(value ast.LoxValue) int {
+	if value.Type == ast.LT_STRING {
+		return len(value.AsString())
+	}
+	return 0
+}
+
+// Returns the approximate number of bytes used by all scopes and the variables declared in them, including
+// the strings stored in the variables
+func (env environment) size() int {
+	env.global.mu.RLock()
+	globals, globalStrings := len(env.global.vars), env.global.strings
+	env.global.mu.RUnlock()
+
+	return (globals+env.vars)*varSize + (len(env.scopes)+1)*scopeSize + globalStrings + env.strings
+}
@@ -2,17 +2,20 @@ package interp
 
 import (
 	"fmt"
+	"math"
+	"slices"
 	"toterich/golox/ast"
 	"toterich/golox/util"
 	"toterich/golox/util/assert"
 )
 
-func (i Interpreter) Evaluate(expr ast.Expr) (ast.LoxValue, error) {
-	switch expr := expr.(type) {
+// Evaluates the expression with the given ID in the tree being executed
+func (i *Interpreter) Evaluate(id ast.ExprID) (ast.LoxValue, error) {
+	switch expr := i.tree.Expr(id).(type) {
 	case *ast.LiteralExpr:
 		return expr.Token.Literal, nil
 	case *ast.IdentifierExpr:
-		val, ok := i.env.getVar(expr.Token.Lexeme)
+		val, ok := i.env.getVar(expr.Token.Symbol)
 		if !ok {
 			return val, util.NewRuntimeError(expr.Token, "undeclared identifier.")
 		}
@@ -21,22 +24,39 @@ func (i Interpreter) Evaluate(expr ast.Expr) (ast.LoxValue, error) {
 		return i.evalUnary(expr)
 	case *ast.BinaryExpr:
 		return i.evalBinary(expr)
+	case *ast.SequenceExpr:
+		return i.evalSequence(expr)
+	case *ast.ConditionalExpr:
+		return i.evalConditional(expr)
 	case *ast.GroupingExpr:
 		return i.evalGrouping(expr)
 	case *ast.AssignExpr:
 		return i.evalAssignment(expr)
+	case *ast.CompoundAssignExpr:
+		return i.evalCompoundAssignment(expr)
+	case *ast.IncrementExpr:
+		return i.evalIncrement(expr)
 	case *ast.OrExpr:
 		return i.evalOr(expr)
 	case *ast.AndExpr:
 		return i.evalAnd(expr)
 	case *ast.CallExpr:
 		return i.evalCall(expr)
+	case *ast.GetExpr:
+		return i.evalGet(expr)
+	case *ast.FunctionExpr:
+		fun := &ast.LoxFunction{Params: expr.Params, Body: expr.Body, Ast: i.tree}
+		return ast.NewFunction(fun), nil
+	case *ast.SpawnExpr:
+		return i.evalSpawn(expr)
+	case *ast.AwaitExpr:
+		return i.evalAwait(expr)
 	default:
 		panic(assert.MissingCase(expr))
 	}
 }
 
-func (i Interpreter) evalUnary(expr *ast.UnaryExpr) (ast.LoxValue, error) {
+func (i *Interpreter) evalUnary(expr *ast.UnaryExpr) (ast.LoxValue, error) {
 	right, err := i.Evaluate(expr.Operand)
 	if err != nil {
 		return right, err
@@ -50,13 +70,19 @@ func (i Interpreter) evalUnary(expr *ast.UnaryExpr) (ast.LoxValue, error) {
 		}
 		return ast.NewNumberValue(-right.AsNumber()), nil
 	case ast.BANG:
-		return ast.NewBoolValue(right.IsTruthy()), nil
+		return ast.NewBoolValue(!right.IsTruthy()), nil
+	case ast.TILDE:
+		operand, err := checkInteger(expr.Operator, right)
+		if err != nil {
+			return right, err
+		}
+		return ast.NewNumberValue(float64(^operand)), nil
 	}
 
 	panic(assert.MissingCase(expr.Operator.Type))
 }
 
-func (i Interpreter) evalBinary(expr *ast.BinaryExpr) (ast.LoxValue, error) {
+func (i *Interpreter) evalBinary(expr *ast.BinaryExpr) (ast.LoxValue, error) {
 	left, err := i.Evaluate(expr.Left)
 	if err != nil {
 		return left, err
@@ -66,58 +92,101 @@ func (i Interpreter) evalBinary(expr *ast.BinaryExpr) (ast.LoxValue, error) {
 		return right, err
 	}
 
-	switch expr.Operator.Type {
+	return i.binaryOperation(expr.Operator, left, right)
+}
+
+// Applies a binary operator to already evaluated operands
+func (i *Interpreter) binaryOperation(operator ast.Token, left ast.LoxValue, right ast.LoxValue) (ast.LoxValue, error) {
+	var err error
+
+	switch operator.Type {
 	case ast.MINUS:
-		err = checkTypes(expr.Operator, []ast.LoxType{ast.LT_NUMBER, ast.LT_NUMBER}, []ast.LoxType{left.Type, right.Type})
+		err = checkTypes(operator, []ast.LoxType{ast.LT_NUMBER, ast.LT_NUMBER}, []ast.LoxType{left.Type, right.Type})
 		if err != nil {
 			return ast.NewNilValue(), err
 		}
 		return ast.NewNumberValue(left.AsNumber() - right.AsNumber()), nil
 	case ast.SLASH:
-		err = checkTypes(expr.Operator, []ast.LoxType{ast.LT_NUMBER, ast.LT_NUMBER}, []ast.LoxType{left.Type, right.Type})
+		err = checkTypes(operator, []ast.LoxType{ast.LT_NUMBER, ast.LT_NUMBER}, []ast.LoxType{left.Type, right.Type})
 		if err != nil {
 			return ast.NewNilValue(), err
 		}
 		if right.AsNumber() == 0 {
-			return ast.NewNilValue(), util.NewRuntimeError(expr.Operator, "division by zero")
+			return ast.NewNilValue(), util.NewRuntimeError(operator, "division by zero")
 		}
 		return ast.NewNumberValue(left.AsNumber() / right.AsNumber()), nil
+	case ast.TILDE_SLASH:
+		err = checkTypes(operator, []ast.LoxType{ast.LT_NUMBER, ast.LT_NUMBER}, []ast.LoxType{left.Type, right.Type})
+		if err != nil {
+			return ast.NewNilValue(), err
+		}
+		if right.AsNumber() == 0 {
+			return ast.NewNilValue(), util.NewRuntimeError(operator, "division by zero")
+		}
+		return ast.NewNumberValue(math.Floor(left.AsNumber() / right.AsNumber())), nil
+	case ast.PERCENT:
+		err = checkTypes(operator, []ast.LoxType{ast.LT_NUMBER, ast.LT_NUMBER}, []ast.LoxType{left.Type, right.Type})
+		if err != nil {
+			return ast.NewNilValue(), err
+		}
+		if right.AsNumber() == 0 {
+			return ast.NewNilValue(), util.NewRuntimeError(operator, "division by zero")
+		}
+		// The result has the sign of the divisor, so that a == (a ~/ b) * b + a % b
+		mod := math.Mod(left.AsNumber(), right.AsNumber())
+		if mod != 0 && (mod < 0) != (right.AsNumber() < 0) {
+			mod += right.AsNumber()
+		}
+		return ast.NewNumberValue(mod), nil
 	case ast.STAR:
-		err = checkTypes(expr.Operator, []ast.LoxType{ast.LT_NUMBER, ast.LT_NUMBER}, []ast.LoxType{left.Type, right.Type})
+		err = checkTypes(operator, []ast.LoxType{ast.LT_NUMBER, ast.LT_NUMBER}, []ast.LoxType{left.Type, right.Type})
 		if err != nil {
 			return ast.NewNilValue(), err
 		}
 		return ast.NewNumberValue(left.AsNumber() * right.AsNumber()), nil
+	case ast.STAR_STAR:
+		err = checkTypes(operator, []ast.LoxType{ast.LT_NUMBER, ast.LT_NUMBER}, []ast.LoxType{left.Type, right.Type})
+		if err != nil {
+			return ast.NewNilValue(), err
+		}
+		return ast.NewNumberValue(math.Pow(left.AsNumber(), right.AsNumber())), nil
+	case ast.AMPERSAND, ast.PIPE, ast.CARET, ast.LESS_LESS, ast.GREATER_GREATER:
+		return evalBitwise(operator, left, right)
 	case ast.PLUS:
 		if left.Type == ast.LT_NUMBER && right.Type == ast.LT_NUMBER {
 			return ast.NewNumberValue(left.AsNumber() + right.AsNumber()), nil
 		} else if left.Type == ast.LT_STRING && right.Type == ast.LT_STRING {
-			return ast.NewStringValue(left.AsString() + right.AsString()), nil
+			// Check before concatenating, so a runaway program can't allocate a huge string first
+			err := i.checkMemory(operator, len(left.AsString())+len(right.AsString()))
+			if err != nil {
+				return ast.NewNilValue(), err
+			}
+			return ast.NewRuntimeStringValue(left.AsString() + right.AsString()), nil
 		} else {
 			return ast.NewNilValue(),
-				util.NewRuntimeError(expr.Operator,
+				util.NewRuntimeError(operator,
 					fmt.Sprintf("Expected either [Number Number] or [String String] as operator's arguments, got [%s %s]", left.Type, right.Type))
 		}
 	case ast.GREATER:
-		err = checkTypes(expr.Operator, []ast.LoxType{ast.LT_NUMBER, ast.LT_NUMBER}, []ast.LoxType{left.Type, right.Type})
+		err = checkTypes(operator, []ast.LoxType{ast.LT_NUMBER, ast.LT_NUMBER}, []ast.LoxType{left.Type, right.Type})
 		if err != nil {
 			return ast.NewNilValue(), err
 		}
 		return ast.NewBoolValue(left.AsNumber() > right.AsNumber()), nil
 	case ast.GREATER_EQUAL:
-		err = checkTypes(expr.Operator, []ast.LoxType{ast.LT_NUMBER, ast.LT_NUMBER}, []ast.LoxType{left.Type, right.Type})
+		err = checkTypes(operator, []ast.LoxType{ast.LT_NUMBER, ast.LT_NUMBER}, []ast.LoxType{left.Type, right.Type})
 		if err != nil {
 			return ast.NewNilValue(), err
 		}
 		return ast.NewBoolValue(left.AsNumber() >= right.AsNumber()), nil
 	case ast.LESS:
-		err = checkTypes(expr.Operator, []ast.LoxType{ast.LT_NUMBER, ast.LT_NUMBER}, []ast.LoxType{left.Type, right.Type})
+		err = checkTypes(operator, []ast.LoxType{ast.LT_NUMBER, ast.LT_NUMBER}, []ast.LoxType{left.Type, right.Type})
 		if err != nil {
 			return ast.NewNilValue(), err
 		}
 		return ast.NewBoolValue(left.AsNumber() < right.AsNumber()), nil
 	case ast.LESS_EQUAL:
-		err = checkTypes(expr.Operator, []ast.LoxType{ast.LT_NUMBER, ast.LT_NUMBER}, []ast.LoxType{left.Type, right.Type})
+		err = checkTypes(operator, []ast.LoxType{ast.LT_NUMBER, ast.LT_NUMBER}, []ast.LoxType{left.Type, right.Type})
 		if err != nil {
 			return ast.NewNilValue(), err
 		}
@@ -128,15 +197,76 @@ func (i Interpreter) evalBinary(expr *ast.BinaryExpr) (ast.LoxValue, error) {
 		return ast.NewBoolValue(left.IsEqual(right)), nil
 	}
 
-	panic(assert.MissingCase(expr.Operator.Type))
+	panic(assert.MissingCase(operator.Type))
 }
 
-func (i Interpreter) evalGrouping(expr *ast.GroupingExpr) (ast.LoxValue, error) {
+// Evaluates a binary operator that requires integral operands
+func evalBitwise(operator ast.Token, left ast.LoxValue, right ast.LoxValue) (ast.LoxValue, error) {
+	err := checkTypes(operator, []ast.LoxType{ast.LT_NUMBER, ast.LT_NUMBER}, []ast.LoxType{left.Type, right.Type})
+	if err != nil {
+		return ast.NewNilValue(), err
+	}
+	l, err := checkInteger(operator, left)
+	if err != nil {
+		return ast.NewNilValue(), err
+	}
+	r, err := checkInteger(operator, right)
+	if err != nil {
+		return ast.NewNilValue(), err
+	}
+
+	switch operator.Type {
+	case ast.AMPERSAND:
+		return ast.NewNumberValue(float64(l & r)), nil
+	case ast.PIPE:
+		return ast.NewNumberValue(float64(l | r)), nil
+	case ast.CARET:
+		return ast.NewNumberValue(float64(l ^ r)), nil
+	case ast.LESS_LESS, ast.GREATER_GREATER:
+		if r < 0 {
+			return ast.NewNilValue(), util.NewRuntimeError(operator, "negative shift count")
+		}
+		if operator.Type == ast.LESS_LESS {
+			return ast.NewNumberValue(float64(l << r)), nil
+		}
+		return ast.NewNumberValue(float64(l >> r)), nil
+	}
+
+	panic(assert.MissingCase(operator.Type))
+}
+
+func (i *Interpreter) evalSequence(expr *ast.SequenceExpr) (ast.LoxValue, error) {
+	value := ast.NewNilValue()
+	for _, expr := range expr.Exprs {
+		var err error
+		value, err = i.Evaluate(expr)
+		if err != nil {
+			return value, err
+		}
+	}
+
+	return value, nil
+}
+
+func (i *Interpreter) evalConditional(expr *ast.ConditionalExpr) (ast.LoxValue, error) {
+	condition, err := i.Evaluate(expr.Condition)
+	if err != nil {
+		return condition, err
+	}
+
+	// Only the selected branch is evaluated
+	if condition.IsTruthy() {
+		return i.Evaluate(expr.Then)
+	}
+	return i.Evaluate(expr.Else)
+}
+
+func (i *Interpreter) evalGrouping(expr *ast.GroupingExpr) (ast.LoxValue, error) {
 	return i.Evaluate(expr.Grouped)
 }
 
-func (i Interpreter) evalAssignment(expr *ast.AssignExpr) (ast.LoxValue, error) {
-	_, ok := i.env.getVar(expr.Target.Lexeme)
+func (i *Interpreter) evalAssignment(expr *ast.AssignExpr) (ast.LoxValue, error) {
+	_, ok := i.env.getVar(expr.Target.Symbol)
 	if !ok {
 		return ast.NewNilValue(), util.NewRuntimeError(expr.Target, "left hand side of assignment has not been declared")
 	}
@@ -147,17 +277,96 @@ func (i Interpreter) evalAssignment(expr *ast.AssignExpr) (ast.LoxValue, error)
 	}
 
 	// This is already checked by getVar above
-	assert.Assert(i.env.assignVal(expr.Target.Lexeme, val), "identifier to be assigned to has not been declared")
+	assert.Assert(i.env.assignVal(expr.Target.Symbol, val), "identifier to be assigned to has not been declared")
+	if i.tracer != nil {
+		i.tracer.Assign(i.traceFrame(), expr.Target.Lexeme, val, expr.Target.Line)
+	}
 
 	return val, nil
 }
 
-func (i Interpreter) evalOr(expr *ast.OrExpr) (ast.LoxValue, error) {
+// Maps the operator of a compound assignment to the binary operator it applies
+var compoundOperators = map[ast.TokenType]ast.TokenType{
+	ast.PLUS_EQUAL:  ast.PLUS,
+	ast.MINUS_EQUAL: ast.MINUS,
+	ast.STAR_EQUAL:  ast.STAR,
+	ast.SLASH_EQUAL: ast.SLASH,
+}
+
+func (i *Interpreter) evalCompoundAssignment(expr *ast.CompoundAssignExpr) (ast.LoxValue, error) {
+	current, ok := i.env.getVar(expr.Target.Symbol)
+	if !ok {
+		return ast.NewNilValue(), util.NewRuntimeError(expr.Target, "left hand side of assignment has not been declared")
+	}
+
+	val, err := i.Evaluate(expr.Value)
+	if err != nil {
+		return val, err
+	}
+
+	operator, ok := compoundOperators[expr.Operator.Type]
+	if !ok {
+		panic(assert.MissingCase(expr.Operator.Type))
+	}
+
+	// Keep the lexeme of the compound operator, so that errors point to it
+	binaryOperator := expr.Operator
+	binaryOperator.Type = operator
+	result, err := i.binaryOperation(binaryOperator, current, val)
+	if err != nil {
+		return result, err
+	}
+
+	assert.Assert(i.env.assignVal(expr.Target.Symbol, result), "identifier to be assigned to has not been declared")
+	if i.tracer != nil {
+		i.tracer.Assign(i.traceFrame(), expr.Target.Lexeme, result, expr.Target.Line)
+	}
+
+	return result, nil
+}
+
+func (i *Interpreter) evalIncrement(expr *ast.IncrementExpr) (ast.LoxValue, error) {
+	current, ok := i.env.getVar(expr.Target.Symbol)
+	if !ok {
+		return ast.NewNilValue(), util.NewRuntimeError(expr.Target, "operand of increment has not been declared")
+	}
+
+	err := checkType(expr.Operator, ast.LT_NUMBER, current.Type)
+	if err != nil {
+		return current, err
+	}
+
+	var result ast.LoxValue
+	switch expr.Operator.Type {
+	case ast.PLUS_PLUS:
+		result = ast.NewNumberValue(current.AsNumber() + 1)
+	case ast.MINUS_MINUS:
+		result = ast.NewNumberValue(current.AsNumber() - 1)
+	default:
+		panic(assert.MissingCase(expr.Operator.Type))
+	}
+
+	assert.Assert(i.env.assignVal(expr.Target.Symbol, result), "identifier to be assigned to has not been declared")
+	if i.tracer != nil {
+		i.tracer.Assign(i.traceFrame(), expr.Target.Lexeme, result, expr.Target.Line)
+	}
+
+	if expr.Prefix {
+		return result, nil
+	}
+	return current, nil
+}
+
+func (i *Interpreter) evalOr(expr *ast.OrExpr) (ast.LoxValue, error) {
 	leftVal, err := i.Evaluate(expr.Left)
 	if err != nil {
 		return leftVal, err
 	}
 
+	if i.coverage != nil {
+		i.coverage.branch(expr, !leftVal.IsTruthy())
+	}
+
 	// Short circuit
 	if leftVal.IsTruthy() {
 		return ast.NewBoolValue(true), nil
@@ -167,12 +376,16 @@ func (i Interpreter) evalOr(expr *ast.OrExpr) (ast.LoxValue, error) {
 	return ast.NewBoolValue(rightVal.IsTruthy()), err
 }
 
-func (i Interpreter) evalAnd(expr *ast.AndExpr) (ast.LoxValue, error) {
+func (i *Interpreter) evalAnd(expr *ast.AndExpr) (ast.LoxValue, error) {
 	leftVal, err := i.Evaluate(expr.Left)
 	if err != nil {
 		return leftVal, err
 	}
 
+	if i.coverage != nil {
+		i.coverage.branch(expr, leftVal.IsTruthy())
+	}
+
 	// Short circuit
 	if !leftVal.IsTruthy() {
 		return ast.NewBoolValue(false), nil
@@ -182,16 +395,11 @@ func (i Interpreter) evalAnd(expr *ast.AndExpr) (ast.LoxValue, error) {
 	return ast.NewBoolValue(rightVal.IsTruthy()), err
 }
 
-func (i Interpreter) evalCall(expr *ast.CallExpr) (ast.LoxValue, error) {
-	callee, err := i.Evaluate(expr.Callee)
+func (i *Interpreter) evalCall(expr *ast.CallExpr) (ast.LoxValue, error) {
+	fun, err := i.evalCallee(expr)
 	if err != nil {
-		return callee, err
-	}
-
-	if callee.Type != ast.LT_FUNCTION {
-		return callee, util.NewRuntimeError(expr.Location, "callee is not callable.")
+		return ast.NewNilValue(), err
 	}
-	fun := callee.AsFunction()
 
 	var args []ast.LoxValue
 	for _, arg := range expr.Arguments {
@@ -202,18 +410,77 @@ func (i Interpreter) evalCall(expr *ast.CallExpr) (ast.LoxValue, error) {
 		args = append(args, arg)
 	}
 
+	err = checkArity(fun, args, expr.Location)
+	if err != nil {
+		return ast.NewNilValue(), err
+	}
+
+	return i.call(fun, args, expr.Location)
+}
+
+// Evaluates the callee of a call and checks that it is callable
+func (i *Interpreter) evalCallee(expr *ast.CallExpr) (*ast.LoxFunction, error) {
+	callee, err := i.Evaluate(expr.Callee)
+	if err != nil {
+		return nil, err
+	}
+
+	if callee.Type != ast.LT_FUNCTION {
+		return nil, util.NewRuntimeError(expr.Location, "callee is not callable.")
+	}
+	return callee.AsFunction(), nil
+}
+
+func checkArity(fun *ast.LoxFunction, args []ast.LoxValue, location ast.Token) error {
 	if fun.Arity() != len(args) {
-		return callee, util.NewRuntimeError(expr.Location,
-			fmt.Sprintf("callee expects %d arguments, got %d", fun.Arity(), len(args)))
+		return util.NewRuntimeError(location, fmt.Sprintf("callee expects %d arguments, got %d", fun.Arity(), len(args)))
+	}
+	return nil
+}
+
+func (i *Interpreter) evalGet(expr *ast.GetExpr) (ast.LoxValue, error) {
+	object, err := i.Evaluate(expr.Object)
+	if err != nil {
+		return object, err
+	}
+
+	if object.Type == ast.LT_CHANNEL {
+		return i.channelMethod(object.AsChannel(), expr.Name)
+	}
+
+	if object.Type != ast.LT_ERROR {
+		return ast.NewNilValue(), util.NewRuntimeError(expr.Name, fmt.Sprintf("value of type %s has no properties.", object.Type))
+	}
+
+	switch expr.Name.Symbol {
+	case i.symbols.message:
+		return ast.NewRuntimeStringValue(object.AsError().Message), nil
+	case i.symbols.line:
+		return ast.NewNumberValue(float64(object.AsError().Line)), nil
 	}
 
-	return i.call(fun, args)
+	return ast.NewNilValue(), util.NewRuntimeError(expr.Name, fmt.Sprintf("undefined property '%s'.", expr.Name.Lexeme))
 }
 
 func checkType(token ast.Token, expected ast.LoxType, actual ast.LoxType) error {
 	return checkTypes(token, []ast.LoxType{expected}, []ast.LoxType{actual})
 }
 
+// Checks that value is a Number without a fractional part that fits into 64 bits and returns it as an integer
+func checkInteger(token ast.Token, value ast.LoxValue) (int64, error) {
+	err := checkType(token, ast.LT_NUMBER, value.Type)
+	if err != nil {
+		return 0, err
+	}
+
+	num := value.AsNumber()
+	if num != math.Trunc(num) || num < math.MinInt64 || num >= math.MaxInt64 {
+		return 0, util.NewRuntimeError(token, fmt.Sprintf("Expected integral Number as argument, got %s", value))
+	}
+
+	return int64(num), nil
+}
+
 func checkTypes(token ast.Token, expected []ast.LoxType, actual []ast.LoxType) error {
 	assert.Assert(len(expected) == len(actual), "expected and actual need to be of equal length")
 
@@ -229,11 +496,13 @@ func checkTypes(token ast.Token, expected []ast.LoxType, actual []ast.LoxType) e
 		return nil
 	}
 
+	// Only copies of the type slices are passed to fmt, so the slices themselves don't escape and callers can
+	// pass literals without allocating
 	var msg string
 	if len(expected) == 1 {
 		msg = fmt.Sprintf("Expected %s as argument, got %s", expected[0], actual[0])
 	} else {
-		msg = fmt.Sprintf("Expected %s as arguments, got %s", expected, actual)
+		msg = fmt.Sprintf("Expected %s as arguments, got %s", slices.Clone(expected), slices.Clone(actual))
 	}
 
 	return util.NewRuntimeError(token, msg)
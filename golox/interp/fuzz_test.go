@@ -0,0 +1,34 @@
+package interp
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+	"toterich/golox/typecheck"
+	"toterich/golox/util/fuzzseed"
+)
+
+func FuzzExecute(f *testing.F) {
+	fuzzseed.AddScripts(f, "../../lox_spec/samples", "../../lox_spec/tests")
+
+	f.Fuzz(func(t *testing.T, source string) {
+		program, errs := Compile(source)
+		if errs != nil {
+			return
+		}
+
+		// Type checking must not fail on any program either, but doesn't decide whether it is executed
+		typecheck.Check(program.Ast())
+
+		// Any program that runs into a limit or the timeout, or fails otherwise, is fine as long as it
+		// doesn't panic
+		interpreter := program.NewInterpreter()
+		interpreter.SetLimits(Limits{MaxSteps: 10_000, MaxCallDepth: 200, MaxMemory: 1 << 20})
+		interpreter.SetOutput(io.Discard)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		interpreter.Run(ctx, program)
+	})
+}
@@ -1,22 +1,154 @@
 package interp
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync/atomic"
 	"toterich/golox/ast"
+	"toterich/golox/util"
 	"toterich/golox/util/assert"
 )
 
 type Interpreter struct {
-	env     environment
-	doBreak bool
+	tree        *ast.Ast // Resolves the IDs of the nodes being executed
+	strings     *ast.StringTable
+	symbols     symbols
+	env         environment
+	doBreak     bool
+	doContinue  bool
+	doReturn    bool
+	returnValue ast.LoxValue
+	limits      Limits
+	steps       *atomic.Int64 // Number of statements executed since the limits were set, shared with spawned tasks
+	depth       int           // Number of active function calls
+	ctx         context.Context
+	done        <-chan struct{} // Closed when ctx is canceled, nil if it can't be
+	tasks       *taskGroup
+	out         io.Writer // Where print statements write to
+	profile     profileCursor
+	coverage    *Coverage // Shared with spawned tasks, nil unless coverage is recorded
+	tracer      Tracer    // Shared with spawned tasks, nil unless execution is traced
+	function    string    // Name of the function being executed while tracing, empty at the top level
 }
 
+// Names the interpreter looks up itself, interned once so they can be compared to identifiers directly
+type symbols struct {
+	message ast.Symbol
+	line    ast.Symbol
+	send    ast.Symbol
+	receive ast.Symbol
+	close   ast.Symbol
+	channel ast.Symbol
+}
+
+func internSymbols(strings *ast.StringTable) symbols {
+	return symbols{
+		message: strings.Intern("message"),
+		line:    strings.Intern("line"),
+		send:    strings.Intern("send"),
+		receive: strings.Intern("receive"),
+		close:   strings.Intern("close"),
+		channel: strings.Intern(channelFunction.Name),
+	}
+}
+
+// Creates an interpreter which executes statements one after another, all sharing the same global scope.
+// To run a whole program, see Program.NewInterpreter().
 func NewInterpreter() Interpreter {
-	return Interpreter{env: newEnvironment()}
+	strings := ast.NewStringTable()
+	return newInterpreter(strings, internSymbols(strings))
+}
+
+func newInterpreter(strings *ast.StringTable, symbols symbols) Interpreter {
+	i := Interpreter{
+		strings: strings,
+		symbols: symbols,
+		env:     newEnvironment(),
+		limits:  Limits{MaxCallDepth: DefaultMaxCallDepth},
+		steps:   &atomic.Int64{},
+		tasks:   newTaskGroup(),
+		out:     os.Stdout,
+	}
+	i.env.declareVal(symbols.channel, ast.NewFunction(channelFunction))
+	return i
+}
+
+// Returns an interpreter to run a spawned task. It shares the global scope, strings, limits and context with i,
+// but has its own local scopes and control flow.
+func (i *Interpreter) fork() *Interpreter {
+	return &Interpreter{
+		tree:     i.tree,
+		strings:  i.strings,
+		symbols:  i.symbols,
+		env:      i.env.fork(),
+		limits:   i.limits,
+		steps:    i.steps,
+		ctx:      i.ctx,
+		done:     i.done,
+		tasks:    i.tasks,
+		out:      i.out,
+		profile:  i.profile.fork(),
+		coverage: i.coverage,
+		tracer:   i.tracer,
+	}
+}
+
+// Sets where print statements write to, os.Stdout by default. Tasks print concurrently, so w needs to be safe
+// for concurrent use if the program spawns any.
+func (i *Interpreter) SetOutput(w io.Writer) {
+	i.out = w
+}
+
+// Returns the table the interpreter interns strings in. Programs executed by the interpreter need to be scanned
+// with the same table, see parse.NewScanner().
+func (i *Interpreter) Strings() *ast.StringTable {
+	return i.strings
+}
+
+// Executes the given top level statement of tree. Equivalent to ExecuteContext() with a context that is never
+// canceled.
+func (i *Interpreter) Execute(tree *ast.Ast, stmt ast.StmtID) error {
+	return i.ExecuteContext(context.Background(), tree, stmt)
+}
+
+// Executes the given top level statement of tree. If ctx is canceled or its deadline passes, execution stops at
+// the next loop iteration or function call with a RuntimeError caused by ErrInterrupted. Afterwards, the
+// interpreter can be used to execute further statements, also of other trees.
+func (i *Interpreter) ExecuteContext(ctx context.Context, tree *ast.Ast, stmt ast.StmtID) error {
+	i.tree, i.ctx, i.done = tree, ctx, ctx.Done()
+	defer func() { i.tree, i.ctx, i.done = nil, nil, nil }()
+
+	err := i.execute(stmt)
+	if i.profile.profiler != nil {
+		i.profile.pause()
+	}
+	if err != nil {
+		// Control flow that was pending when the error occurred must not leak into the next statement
+		i.doBreak, i.doContinue, i.doReturn = false, false, false
+		i.returnValue = ast.NewNilValue()
+	}
+	return err
 }
 
-func (i *Interpreter) Execute(stmt ast.Stmt) error {
-	var err error
+func (i *Interpreter) execute(id ast.StmtID) error {
+	stmt := i.tree.Stmt(id)
+	if i.profile.profiler != nil {
+		i.profile.line(stmt.StartLine())
+	}
+
+	err := i.step(stmt.StartLine())
+	if err != nil {
+		return err
+	}
+	if i.coverage != nil {
+		i.coverage.statement(stmt)
+	}
+	if i.tracer != nil {
+		i.tracer.Statement(i.traceFrame(), stmt)
+	}
 
 	switch stmt := stmt.(type) {
 
@@ -24,23 +156,31 @@ func (i *Interpreter) Execute(stmt ast.Stmt) error {
 		_, err = i.Evaluate(stmt.Expr)
 
 	case *ast.PrintStmt:
-		value, err := i.Evaluate(stmt.Expr)
+		var value ast.LoxValue
+		value, err = i.Evaluate(stmt.Expr)
 		if err == nil {
-			fmt.Println(value)
+			fmt.Fprintln(i.out, value)
 		}
 
 	case *ast.VarDeclStmt:
-		value, err := i.Evaluate(stmt.Value)
+		// Variables declared without initializer are nil
+		value := ast.NewNilValue()
+		if stmt.Value != 0 {
+			value, err = i.Evaluate(stmt.Value)
+		}
 		if err == nil {
-			i.env.declareVal(stmt.Identifier.Lexeme, value)
+			i.env.declareVal(stmt.Identifier.Symbol, value)
+			if i.tracer != nil {
+				i.tracer.Assign(i.traceFrame(), stmt.Identifier.Lexeme, value, stmt.Identifier.Line)
+			}
 		}
 
 	case *ast.BlockStmt:
 		i.env.push(false)
 
 		for _, child := range stmt.Body {
-			err = i.Execute(child)
-			if err != nil || i.doBreak {
+			err = i.execute(child)
+			if err != nil || i.doBreak || i.doContinue || i.doReturn {
 				break
 			}
 		}
@@ -53,17 +193,41 @@ func (i *Interpreter) Execute(stmt ast.Stmt) error {
 		if err != nil {
 			break
 		}
+		if i.coverage != nil {
+			i.coverage.branch(stmt, doIf.IsTruthy())
+		}
 		if doIf.IsTruthy() {
-			err = i.Execute(stmt.Then)
-		} else if stmt.Else != nil {
-			err = i.Execute(stmt.Else)
+			err = i.execute(stmt.Then)
+		} else if stmt.Else != 0 {
+			err = i.execute(stmt.Else)
 		}
 
 	case *ast.WhileStmt:
 		var doWhile ast.LoxValue
 		doWhile, err = i.Evaluate(stmt.Condition)
-		for doWhile.IsTruthy() && !i.doBreak {
-			err = i.Execute(stmt.Then)
+		if i.coverage != nil && err == nil {
+			i.coverage.branch(stmt, doWhile.IsTruthy())
+		}
+		for doWhile.IsTruthy() {
+			err = i.execute(stmt.Then)
+			// After a break or return, neither the increment nor the condition may be evaluated. Calls in them
+			// would run while the return value is still pending and consume it.
+			if err != nil || i.doBreak || i.doReturn {
+				break
+			}
+			// A continue statement only skips the rest of the body, the increment still runs
+			i.doContinue = false
+			if i.profile.profiler != nil {
+				// The increment and condition are part of the loop's line
+				i.profile.line(stmt.StartLine())
+			}
+			if stmt.Increment != 0 {
+				_, err = i.Evaluate(stmt.Increment)
+				if err != nil {
+					break
+				}
+			}
+			err = i.checkInterrupted(ast.Token{Line: stmt.StartLine()})
 			if err != nil {
 				break
 			}
@@ -71,16 +235,46 @@ func (i *Interpreter) Execute(stmt ast.Stmt) error {
 			if err != nil {
 				break
 			}
+			if i.coverage != nil {
+				i.coverage.branch(stmt, doWhile.IsTruthy())
+			}
 		}
 		// Only break out of the innermost loop
 		i.doBreak = false
 
+	case *ast.SwitchStmt:
+		err = i.executeSwitch(stmt)
+
 	case *ast.BreakStmt:
 		i.doBreak = true
 
+	case *ast.ContinueStmt:
+		i.doContinue = true
+
+	case *ast.ReturnStmt:
+		value := ast.NewNilValue()
+		if stmt.Value != 0 {
+			value, err = i.Evaluate(stmt.Value)
+			if err != nil {
+				break
+			}
+		}
+		i.doReturn = true
+		i.returnValue = value
+
+	case *ast.ThrowStmt:
+		var value ast.LoxValue
+		value, err = i.Evaluate(stmt.Value)
+		if err == nil {
+			err = util.NewLoxException(stmt.Keyword, value)
+		}
+
+	case *ast.TryStmt:
+		err = i.executeTry(stmt)
+
 	case *ast.FunDeclStmt:
-		fun := ast.LoxFunction{Declaration: stmt}
-		i.env.declareVal(stmt.Name.Lexeme, ast.NewFunction(fun))
+		fun := &ast.LoxFunction{Name: stmt.Name.Lexeme, Params: stmt.Params, Body: stmt.Body, Ast: i.tree}
+		i.env.declareVal(stmt.Name.Symbol, ast.NewFunction(fun))
 
 	default:
 		panic(assert.MissingCase(stmt))
@@ -89,23 +283,184 @@ func (i *Interpreter) Execute(stmt ast.Stmt) error {
 	return err
 }
 
-func (i *Interpreter) call(callee ast.LoxFunction, arguments []ast.LoxValue) (ast.LoxValue, error) {
+func (i *Interpreter) executeSwitch(stmt *ast.SwitchStmt) error {
+	value, err := i.Evaluate(stmt.Value)
+	if err != nil {
+		return err
+	}
+
+	// Without a matching case, the default case is executed, if there is one
+	body := stmt.Default
+
+findCase:
+	for _, case_ := range stmt.Cases {
+		for _, caseValue := range case_.Values {
+			caseValue, err := i.Evaluate(caseValue)
+			if err != nil {
+				return err
+			}
+			if value.IsEqual(caseValue) {
+				body = case_.Body
+				break findCase
+			}
+		}
+	}
+
+	if body != 0 {
+		err = i.execute(body)
+	}
+
+	// A break only exits the switch, not any surrounding loop
+	i.doBreak = false
+
+	return err
+}
+
+func (i *Interpreter) executeTry(stmt *ast.TryStmt) error {
+	err := i.execute(stmt.Body)
+
+	if err != nil && stmt.Catch != 0 {
+		if caught, ok := caughtValue(err); ok {
+			i.env.push(false)
+			i.env.declareVal(stmt.CatchName.Symbol, caught)
+			err = i.execute(stmt.Catch)
+			i.env.pop()
+		}
+	}
+
+	if stmt.Finally != 0 {
+		// A break, continue or return pending from the try or catch block is suspended while the finally block
+		// runs. If the finally block raises an error or diverts control flow itself, that takes precedence.
+		doBreak, doContinue, doReturn, returnValue := i.doBreak, i.doContinue, i.doReturn, i.returnValue
+		i.doBreak, i.doContinue, i.doReturn = false, false, false
+
+		finallyErr := i.execute(stmt.Finally)
+		if finallyErr != nil || i.doBreak || i.doContinue || i.doReturn {
+			return finallyErr
+		}
+
+		i.doBreak, i.doContinue, i.doReturn, i.returnValue = doBreak, doContinue, doReturn, returnValue
+	}
+
+	return err
+}
+
+// Returns the Lox value a catch clause binds for the given error. Values thrown by Lox code are caught as they
+// are, runtime errors are converted into error objects. Other errors, including exceeded limits, can't be caught.
+func caughtValue(err error) (ast.LoxValue, bool) {
+	switch err := err.(type) {
+	case util.LoxException:
+		return err.Value, true
+	case util.RuntimeError:
+		if isUncatchable(err) {
+			break
+		}
+		return ast.NewErrorValue(ast.LoxError{Message: err.Msg, Line: err.Token.Line}), true
+	}
+
+	return ast.NewNilValue(), false
+}
+
+// Records that the given error propagated out of a call to the named function at the location of the call,
+// so that uncaught errors can be reported with a stack trace.
+func addStackFrame(err error, function string, location ast.Token) error {
+	frame := util.StackFrame{Function: function, Line: location.Line}
+
+	switch err := err.(type) {
+	case util.LoxException:
+		err.Trace = append(err.Trace, frame)
+		return err
+	case util.RuntimeError:
+		err.Trace = append(err.Trace, frame)
+		return err
+	}
+
+	return err
+}
+
+// Returns err with a trace that is safe to extend, even if the same error is also propagated by another task
+func detachTrace(err error) error {
+	switch err := err.(type) {
+	case util.LoxException:
+		err.Trace = slices.Clip(err.Trace)
+		return err
+	case util.RuntimeError:
+		err.Trace = slices.Clip(err.Trace)
+		return err
+	}
+
+	return err
+}
+
+// Returns the name of a function as shown in stack traces and profiles
+func functionName(function *ast.LoxFunction) string {
+	if function.Name == "" {
+		return "<anonymous>"
+	}
+	return function.Name
+}
+
+func (i *Interpreter) call(callee *ast.LoxFunction, arguments []ast.LoxValue, location ast.Token) (ast.LoxValue, error) {
 	// For the duration of the call, create a new environment that only inherits from the global env
 	// TODO: Functions don't necessarily have access to only global scope. For those declared inside
 	// another scope, the call to them should inherit that scope instead
+	if i.limits.MaxCallDepth > 0 && i.depth >= i.limits.MaxCallDepth {
+		return ast.NewNilValue(), util.NewRuntimeErrorWithCause(location, ErrCallDepthLimit)
+	}
+	if err := i.checkInterrupted(location); err != nil {
+		return ast.NewNilValue(), err
+	}
+
+	if i.profile.profiler != nil {
+		line := 0
+		if len(callee.Body) > 0 {
+			line = callee.Ast.Stmt(callee.Body[0]).StartLine()
+		}
+		i.profile.enter(functionName(callee), line)
+		defer i.profile.exit()
+	}
+
+	if i.tracer != nil {
+		return i.traceCall(callee, arguments, location)
+	}
+	return i.invoke(callee, arguments, location)
+}
+
+// Executes the body of a function, or calls the native function
+func (i *Interpreter) invoke(callee *ast.LoxFunction, arguments []ast.LoxValue, location ast.Token) (ast.LoxValue, error) {
+	if callee.Native != nil {
+		return i.callNative(callee, arguments, location)
+	}
+
+	// The body's IDs are resolved by the tree the function was declared in, which differs from the caller's
+	// if the caller has been executed separately, like a line of the REPL
+	tree := i.tree
+	i.tree = callee.Ast
 	i.env.push(true)
-	defer func() { i.env.pop() }()
+	i.depth += 1
+	defer func() {
+		i.tree = tree
+		i.env.pop()
+		i.depth -= 1
+	}()
 
 	// Declare passed function parameters in local env
-	for idx, param := range callee.Declaration.Params {
-		i.env.declareVal(param.Lexeme, arguments[idx])
+	for idx, param := range callee.Params {
+		i.env.declareVal(param.Symbol, arguments[idx])
 	}
 
-	// Execute statements one after another in the local env
-	for _, statement := range callee.Declaration.Body {
-		err := i.Execute(statement)
+	// Execute statements one after another in the local env, until one of them returns
+	for _, statement := range callee.Body {
+		err := i.execute(statement)
 		if err != nil {
-			return ast.NewNilValue(), err
+			return ast.NewNilValue(), addStackFrame(err, functionName(callee), location)
+		}
+
+		if i.doReturn {
+			returnValue := i.returnValue
+			i.doReturn = false
+			i.returnValue = ast.NewNilValue()
+			return returnValue, nil
 		}
 	}
 
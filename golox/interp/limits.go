@@ -0,0 +1,80 @@
+package interp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"toterich/golox/ast"
+	"toterich/golox/util"
+)
+
+// Limits on the resources a program may use, so that untrusted programs can be executed safely.
+// A limit of zero means unlimited.
+type Limits struct {
+	MaxSteps     int // Number of statements executed, by all tasks together
+	MaxCallDepth int // Number of nested function calls in each task
+	MaxMemory    int // Approximate number of bytes used by variables and strings
+}
+
+// The call depth limit of a new interpreter. Deeper recursion would risk overflowing the Go stack.
+const DefaultMaxCallDepth = 10_000
+
+// The causes of the RuntimeErrors returned when a limit is exceeded, use errors.Is() to check for them.
+// Unlike other runtime errors, these can't be caught by a try statement.
+var (
+	ErrStepLimit      = errors.New("step limit exceeded.")
+	ErrCallDepthLimit = errors.New("call depth limit exceeded.")
+	ErrMemoryLimit    = errors.New("memory limit exceeded.")
+)
+
+// The cause of the RuntimeError returned when the context passed to ExecuteContext() is done. The cause also
+// wraps the context's error, so errors.Is(err, context.DeadlineExceeded) distinguishes timeouts.
+// Like exceeded limits, it can't be caught by a try statement.
+var ErrInterrupted = errors.New("execution interrupted")
+
+// Replaces the interpreter's limits and resets the count of executed steps.
+// Tasks that are already running keep the limits they were spawned with.
+func (i *Interpreter) SetLimits(limits Limits) {
+	i.limits = limits
+	i.steps.Store(0)
+}
+
+// Returns the approximate number of bytes currently used by variables and the strings stored in them. Strings
+// that are no longer stored in any variable don't count, the garbage collector frees them.
+func (i *Interpreter) MemoryUsage() int {
+	return i.strings.Size() + i.env.size()
+}
+
+// Counts the execution of a statement starting at the given line and checks it against the limits
+func (i *Interpreter) step(line int) error {
+	if i.limits.MaxSteps > 0 && i.steps.Add(1) > int64(i.limits.MaxSteps) {
+		return util.NewRuntimeErrorWithCause(ast.Token{Line: line}, ErrStepLimit)
+	}
+
+	return i.checkMemory(ast.Token{Line: line}, 0)
+}
+
+// Checks whether the memory used would exceed the limit after allocating additional bytes
+func (i *Interpreter) checkMemory(location ast.Token, additional int) error {
+	if i.limits.MaxMemory > 0 && i.MemoryUsage()+additional > i.limits.MaxMemory {
+		return util.NewRuntimeErrorWithCause(location, ErrMemoryLimit)
+	}
+	return nil
+}
+
+// Checks whether the context of the current execution is done
+func (i *Interpreter) checkInterrupted(location ast.Token) error {
+	select {
+	case <-i.done:
+		return util.NewRuntimeErrorWithCause(location, fmt.Errorf("%w: %w", ErrInterrupted, context.Cause(i.ctx)))
+	default:
+		return nil
+	}
+}
+
+// Returns whether err was caused by exceeding one of the interpreter's limits or by an interruption, which
+// Lox code must not be able to suppress
+func isUncatchable(err error) bool {
+	return errors.Is(err, ErrStepLimit) || errors.Is(err, ErrCallDepthLimit) || errors.Is(err, ErrMemoryLimit) ||
+		errors.Is(err, ErrInterrupted)
+}
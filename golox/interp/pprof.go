@@ -0,0 +1,247 @@
+package interp
+
+import (
+	"cmp"
+	"compress/gzip"
+	"io"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Field numbers of the messages in pprof's profile.proto, see
+// https://github.com/google/pprof/blob/main/proto/profile.proto
+const (
+	fieldProfileSampleType        = 1
+	fieldProfileSample            = 2
+	fieldProfileMapping           = 3
+	fieldProfileLocation          = 4
+	fieldProfileFunction          = 5
+	fieldProfileStringTable       = 6
+	fieldProfileTimeNanos         = 9
+	fieldProfileDurationNanos     = 10
+	fieldProfilePeriodType        = 11
+	fieldProfilePeriod            = 12
+	fieldProfileDefaultSampleType = 14
+
+	fieldValueTypeType = 1
+	fieldValueTypeUnit = 2
+
+	fieldSampleLocationID = 1
+	fieldSampleValue      = 2
+
+	fieldMappingID             = 1
+	fieldMappingFilename       = 5
+	fieldMappingHasFunctions   = 7
+	fieldMappingHasFilenames   = 8
+	fieldMappingHasLineNumbers = 9
+
+	fieldLocationID        = 1
+	fieldLocationMappingID = 2
+	fieldLocationLine      = 4
+
+	fieldLineFunctionID = 1
+	fieldLineLine       = 2
+
+	fieldFunctionID       = 1
+	fieldFunctionName     = 2
+	fieldFunctionFilename = 4
+)
+
+// Writes the profile in the gzip-compressed protocol buffer format read by `go tool pprof`. Each sample has
+// the number of calls and the time spent at a call stack.
+func (p *Profiler) WriteProfile(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e := profileEncoder{
+		strings:     map[string]int64{"": 0},
+		stringTable: []string{""},
+		locations:   map[profileLocation]uint64{},
+		functions:   map[string]uint64{},
+	}
+	var profile protoBuffer
+
+	calls, count := e.string("calls"), e.string("count")
+	time_, nanoseconds := e.string("time"), e.string("nanoseconds")
+	profile.message(fieldProfileSampleType, func(b *protoBuffer) {
+		b.int64(fieldValueTypeType, calls)
+		b.int64(fieldValueTypeUnit, count)
+	})
+	profile.message(fieldProfileSampleType, func(b *protoBuffer) {
+		b.int64(fieldValueTypeType, time_)
+		b.int64(fieldValueTypeUnit, nanoseconds)
+	})
+
+	file := e.string(p.file)
+	var stack []uint64
+	var visit func(node *profileNode)
+	visit = func(node *profileNode) {
+		stack = append(stack, e.location(node.location))
+		if node.calls > 0 || node.time > 0 {
+			profile.message(fieldProfileSample, func(b *protoBuffer) {
+				// Locations are listed from the innermost call to the outermost
+				ids := make([]uint64, len(stack))
+				for idx, id := range stack {
+					ids[len(stack)-1-idx] = id
+				}
+				b.packedUint64(fieldSampleLocationID, ids)
+				b.packedUint64(fieldSampleValue, []uint64{uint64(node.calls), uint64(node.time)})
+			})
+		}
+		for _, child := range sortedChildren(node) {
+			visit(child)
+		}
+		stack = stack[:len(stack)-1]
+	}
+	for _, child := range sortedChildren(p.root) {
+		visit(child)
+	}
+
+	// All locations are in the script, which pprof treats as the main binary
+	profile.message(fieldProfileMapping, func(b *protoBuffer) {
+		b.uint64(fieldMappingID, 1)
+		b.int64(fieldMappingFilename, file)
+		b.uint64(fieldMappingHasFunctions, 1)
+		b.uint64(fieldMappingHasFilenames, 1)
+		b.uint64(fieldMappingHasLineNumbers, 1)
+	})
+	for idx, location := range e.locationList {
+		profile.message(fieldProfileLocation, func(b *protoBuffer) {
+			b.uint64(fieldLocationID, uint64(idx+1))
+			b.uint64(fieldLocationMappingID, 1)
+			b.message(fieldLocationLine, func(b *protoBuffer) {
+				b.uint64(fieldLineFunctionID, e.functions[location.function])
+				b.int64(fieldLineLine, int64(location.line))
+			})
+		})
+	}
+	for idx, function := range e.functionList {
+		name := e.string(function)
+		profile.message(fieldProfileFunction, func(b *protoBuffer) {
+			b.uint64(fieldFunctionID, uint64(idx+1))
+			b.int64(fieldFunctionName, name)
+			b.int64(fieldFunctionFilename, file)
+		})
+	}
+
+	profile.int64(fieldProfileTimeNanos, p.start.UnixNano())
+	profile.int64(fieldProfileDurationNanos, int64(time.Since(p.start)))
+	profile.message(fieldProfilePeriodType, func(b *protoBuffer) {
+		b.int64(fieldValueTypeType, time_)
+		b.int64(fieldValueTypeUnit, nanoseconds)
+	})
+	profile.int64(fieldProfilePeriod, 1)
+	profile.int64(fieldProfileDefaultSampleType, time_)
+
+	// The string table is written last, since the other fields add to it
+	for _, s := range e.stringTable {
+		profile.string(fieldProfileStringTable, s)
+	}
+
+	zw := gzip.NewWriter(w)
+	_, err := zw.Write(profile.data)
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Returns the children of node ordered by their location, so that profiles are written deterministically
+func sortedChildren(node *profileNode) []*profileNode {
+	children := slices.Collect(maps.Values(node.children))
+	slices.SortFunc(children, func(a, b *profileNode) int {
+		return cmp.Or(cmp.Compare(a.location.function, b.location.function), cmp.Compare(a.location.line, b.location.line))
+	})
+	return children
+}
+
+// Assigns the IDs the messages of a profile refer to each other by
+type profileEncoder struct {
+	strings      map[string]int64
+	stringTable  []string
+	locations    map[profileLocation]uint64
+	locationList []profileLocation
+	functions    map[string]uint64
+	functionList []string
+}
+
+func (e *profileEncoder) string(s string) int64 {
+	idx, ok := e.strings[s]
+	if !ok {
+		idx = int64(len(e.stringTable))
+		e.strings[s] = idx
+		e.stringTable = append(e.stringTable, s)
+	}
+	return idx
+}
+
+// IDs of locations and functions start at 1, since 0 is reserved
+func (e *profileEncoder) location(location profileLocation) uint64 {
+	id, ok := e.locations[location]
+	if !ok {
+		e.locationList = append(e.locationList, location)
+		id = uint64(len(e.locationList))
+		e.locations[location] = id
+	}
+	if _, ok := e.functions[location.function]; !ok {
+		e.functionList = append(e.functionList, location.function)
+		e.functions[location.function] = uint64(len(e.functionList))
+	}
+	return id
+}
+
+// Encodes a protocol buffer message. Only the wire types that profile.proto uses are supported.
+type protoBuffer struct {
+	data []byte
+}
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protoBuffer) key(field int, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (b *protoBuffer) uint64(field int, x uint64) {
+	b.key(field, wireVarint)
+	b.varint(x)
+}
+
+func (b *protoBuffer) int64(field int, x int64) {
+	b.uint64(field, uint64(x))
+}
+
+func (b *protoBuffer) packedUint64(field int, xs []uint64) {
+	var packed protoBuffer
+	for _, x := range xs {
+		packed.varint(x)
+	}
+	b.bytes(field, packed.data)
+}
+
+func (b *protoBuffer) string(field int, s string) {
+	b.bytes(field, []byte(s))
+}
+
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.key(field, wireBytes)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+func (b *protoBuffer) message(field int, encode func(b *protoBuffer)) {
+	var message protoBuffer
+	encode(&message)
+	b.bytes(field, message.data)
+}
@@ -0,0 +1,132 @@
+package interp
+
+import (
+	"sync"
+	"time"
+)
+
+// Records how much time a program spends in each Lox function and source line, and how often each function is
+// called. The time is wall-clock time, so tasks that wait for a channel or another task are charged for the time
+// they wait. Write the profile with WriteProfile() once the program has finished.
+type Profiler struct {
+	mu    sync.Mutex
+	file  string // The script, which is the file of every location
+	start time.Time
+	root  *profileNode
+}
+
+// Where in the program time is spent
+type profileLocation struct {
+	function string
+	line     int
+}
+
+// The profile is a tree of locations, in which the children of a node are the locations reached by calls made
+// from it. Each path from the root is a call stack.
+type profileNode struct {
+	location profileLocation
+	parent   *profileNode
+	children map[profileLocation]*profileNode
+	time     time.Duration // Spent at this node itself, excluding its children
+	calls    int64         // Number of calls made to this node's function from its parent
+}
+
+// The name of the outermost function, which executes the top level statements of the script
+const scriptFunction = "<script>"
+
+// Creates a Profiler for the script in file. Pass it to the interpreter that executes the script with
+// SetProfiler().
+func NewProfiler(file string) *Profiler {
+	return &Profiler{file: file, start: time.Now(), root: &profileNode{}}
+}
+
+func (n *profileNode) child(location profileLocation) *profileNode {
+	child, ok := n.children[location]
+	if !ok {
+		if n.children == nil {
+			n.children = map[profileLocation]*profileNode{}
+		}
+		child = &profileNode{location: location, parent: n}
+		n.children[location] = child
+	}
+	return child
+}
+
+// The position of an interpreter in the profile. Each task has its own.
+type profileCursor struct {
+	profiler *Profiler
+	node     *profileNode // The location being executed, the root if none is
+	since    time.Time    // When execution of node started, zero while paused
+}
+
+// Makes the interpreter record its execution in profiler, or stops recording if profiler is nil
+func (i *Interpreter) SetProfiler(profiler *Profiler) {
+	i.profile = profileCursor{profiler: profiler}
+	if profiler != nil {
+		i.profile.node = profiler.root
+	}
+}
+
+// Returns the cursor of a task spawned by the cursor's interpreter. Like the script, the task's call stacks
+// start at the root of the profile.
+func (c *profileCursor) fork() profileCursor {
+	if c.profiler == nil {
+		return profileCursor{}
+	}
+	return profileCursor{profiler: c.profiler, node: c.profiler.root}
+}
+
+// Charges the time since the last event to the current node. Needs to be called with the profiler locked.
+func (c *profileCursor) charge(now time.Time) {
+	if !c.since.IsZero() {
+		c.node.time += now.Sub(c.since)
+	}
+	c.since = now
+}
+
+// Moves to another line of the function being executed. At the top level, which is outside of any function,
+// this enters the script's function.
+func (c *profileCursor) line(line int) {
+	now := time.Now()
+	c.profiler.mu.Lock()
+	defer c.profiler.mu.Unlock()
+
+	c.charge(now)
+	if c.node == c.profiler.root {
+		c.node = c.node.child(profileLocation{scriptFunction, line})
+	} else {
+		c.node = c.node.parent.child(profileLocation{c.node.location.function, line})
+	}
+}
+
+// Enters a function, which starts at the given line
+func (c *profileCursor) enter(function string, line int) {
+	now := time.Now()
+	c.profiler.mu.Lock()
+	defer c.profiler.mu.Unlock()
+
+	c.charge(now)
+	c.node = c.node.child(profileLocation{function, line})
+	c.node.calls += 1
+}
+
+// Returns from the current function to its caller
+func (c *profileCursor) exit() {
+	now := time.Now()
+	c.profiler.mu.Lock()
+	defer c.profiler.mu.Unlock()
+
+	c.charge(now)
+	c.node = c.node.parent
+}
+
+// Stops charging time until execution continues, e.g. between the statements entered into the REPL
+func (c *profileCursor) pause() {
+	now := time.Now()
+	c.profiler.mu.Lock()
+	defer c.profiler.mu.Unlock()
+
+	c.charge(now)
+	c.since = time.Time{}
+	c.node = c.profiler.root
+}
@@ -0,0 +1,189 @@
+package interp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"slices"
+	"testing"
+)
+
+const profiledSource = `
+fun fib(n) {
+  if (n < 2) return n;
+  return fib(n - 1) + fib(n - 2);
+}
+fun count() {
+  var i = 0;
+  while (i < 100) i++;
+}
+var task = spawn count();
+fib(10);
+await task;
+`
+
+func profileProgram(t *testing.T, source string) *Profiler {
+	t.Helper()
+
+	profiler := NewProfiler("test.lox")
+	mustRunWith(t, source, func(_ *Program, interpreter *Interpreter) {
+		interpreter.SetProfiler(profiler)
+	})
+	return profiler
+}
+
+// Visits the nodes below node with the call stack of each, outermost call first
+func profileNodes(node *profileNode, stack []profileLocation, visit func(node *profileNode, stack []profileLocation)) {
+	for _, child := range node.children {
+		childStack := append(slices.Clip(stack), child.location)
+		visit(child, childStack)
+		profileNodes(child, childStack, visit)
+	}
+}
+
+func TestProfileCallsAndLines(t *testing.T) {
+	profiler := profileProgram(t, profiledSource)
+
+	calls := map[string]int64{}
+	var lines []profileLocation
+	profileNodes(profiler.root, nil, func(node *profileNode, stack []profileLocation) {
+		calls[node.location.function] += node.calls
+		if !slices.Contains(lines, node.location) {
+			lines = append(lines, node.location)
+		}
+
+		// Functions are called from the script, recursively or run as a task
+		outermost := stack[0].function
+		if outermost != scriptFunction && outermost != "count" {
+			t.Errorf("call stack %v doesn't start in the script or a task", stack)
+		}
+	})
+
+	if calls["fib"] != 177 {
+		t.Errorf("expected 177 calls of fib, got %d", calls["fib"])
+	}
+	if calls["count"] != 1 {
+		t.Errorf("expected 1 call of count, got %d", calls["count"])
+	}
+
+	for _, expected := range []profileLocation{
+		{scriptFunction, 10}, {scriptFunction, 11}, {scriptFunction, 12},
+		{"fib", 3}, {"fib", 4}, {"count", 7}, {"count", 8},
+	} {
+		if !slices.Contains(lines, expected) {
+			t.Errorf("profile has no location %v, got %v", expected, lines)
+		}
+	}
+}
+
+// Decodes a protocol buffer message into the encoded values of each of its fields, in order
+func decodeProto(t *testing.T, data []byte) map[int][][]byte {
+	t.Helper()
+
+	fields := map[int][][]byte{}
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		data = data[n:]
+
+		field := int(key >> 3)
+		switch key & 7 {
+		case wireVarint:
+			_, n := binary.Uvarint(data)
+			fields[field] = append(fields[field], data[:n])
+			data = data[n:]
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			data = data[n:]
+			fields[field] = append(fields[field], data[:length])
+			data = data[length:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+	return fields
+}
+
+func decodeVarint(data []byte) uint64 {
+	x, _ := binary.Uvarint(data)
+	return x
+}
+
+func TestWriteProfile(t *testing.T) {
+	profiler := profileProgram(t, profiledSource)
+
+	var out bytes.Buffer
+	err := profiler.WriteProfile(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile := decodeProto(t, data)
+	var stringTable []string
+	for _, s := range profile[fieldProfileStringTable] {
+		stringTable = append(stringTable, string(s))
+	}
+	if stringTable[0] != "" {
+		t.Errorf("the string table needs to start with an empty string, got %q", stringTable[0])
+	}
+
+	functions := map[uint64]string{}
+	for _, function := range profile[fieldProfileFunction] {
+		fields := decodeProto(t, function)
+		functions[decodeVarint(fields[fieldFunctionID][0])] = stringTable[decodeVarint(fields[fieldFunctionName][0])]
+		if file := stringTable[decodeVarint(fields[fieldFunctionFilename][0])]; file != "test.lox" {
+			t.Errorf("expected functions in test.lox, got %s", file)
+		}
+	}
+
+	locations := map[uint64]profileLocation{}
+	for _, location := range profile[fieldProfileLocation] {
+		fields := decodeProto(t, location)
+		line := decodeProto(t, fields[fieldLocationLine][0])
+		locations[decodeVarint(fields[fieldLocationID][0])] = profileLocation{
+			function: functions[decodeVarint(line[fieldLineFunctionID][0])],
+			line:     int(decodeVarint(line[fieldLineLine][0])),
+		}
+	}
+
+	// The samples of the recursive calls all end in fib, the outermost one is called from the script
+	fibCalls := uint64(0)
+	for _, sample := range profile[fieldProfileSample] {
+		fields := decodeProto(t, sample)
+
+		var stack []profileLocation
+		for ids := fields[fieldSampleLocationID][0]; len(ids) > 0; {
+			id, n := binary.Uvarint(ids)
+			ids = ids[n:]
+			location, ok := locations[id]
+			if !ok {
+				t.Fatalf("sample refers to unknown location %d", id)
+			}
+			stack = append(stack, location)
+		}
+
+		values := fields[fieldSampleValue][0]
+		calls, n := binary.Uvarint(values)
+		if stack[0].function == "fib" {
+			fibCalls += calls
+			if outermost := stack[len(stack)-1]; outermost != (profileLocation{scriptFunction, 11}) {
+				t.Errorf("expected the calls of fib to start at line 11 of the script, got %v", outermost)
+			}
+		}
+		if _, m := binary.Uvarint(values[n:]); m == 0 {
+			t.Errorf("sample has no time value")
+		}
+	}
+
+	if fibCalls != 177 {
+		t.Errorf("expected 177 calls of fib, got %d", fibCalls)
+	}
+}
@@ -0,0 +1,74 @@
+package interp
+
+import (
+	"context"
+	"errors"
+	"toterich/golox/ast"
+	"toterich/golox/parse"
+)
+
+// A parsed Lox program. A Program is immutable once it has been compiled, so it can be run any number of times,
+// also concurrently, each time by a new interpreter from NewInterpreter().
+type Program struct {
+	tree    *ast.Ast
+	strings *ast.StringTable // Identifiers and string constants of the program, must not be modified
+	symbols symbols
+}
+
+// Scans and parses the source of a program. Returns the lexical errors if there are any, otherwise the syntax
+// errors.
+func Compile(source string) (*Program, []error) {
+	strings := ast.NewStringTable()
+	scanner := parse.NewScanner(strings)
+	var parser parse.Parser
+
+	tokens, errs := scanner.ScanTokens(source)
+	if errs != nil {
+		return nil, errs
+	}
+
+	tree, errs := parser.Parse(tokens)
+	if errs != nil {
+		return nil, errs
+	}
+
+	// Intern the interpreter's own names here, so interpreters don't need to add them to their tables
+	return &Program{tree: tree, strings: strings, symbols: internSymbols(strings)}, nil
+}
+
+// Returns the parsed program, which must not be modified
+func (p *Program) Ast() *ast.Ast {
+	return p.tree
+}
+
+// Creates an interpreter to run the program with, which has its own global scope. Strings scanned into the
+// interpreter's Strings() are interned in a table of its own, so runs don't affect each other.
+func (p *Program) NewInterpreter() Interpreter {
+	return newInterpreter(ast.NewChildStringTable(p.strings), p.symbols)
+}
+
+// Executes all statements of the program until one of them fails or ctx is done, then waits for the tasks it
+// spawned. Returns the error of the failed statement, or those of tasks that failed without being awaited.
+// If a statement fails, the tasks that are still running are interrupted, so none of them outlive the run.
+// i needs to have been created by program.NewInterpreter().
+func (i *Interpreter) Run(ctx context.Context, program *Program) []error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for _, stmt := range program.tree.Body {
+		err := i.ExecuteContext(ctx, program.tree, stmt)
+		if err != nil {
+			cancel()
+			errs := []error{err}
+			for _, err := range i.Wait() {
+				// Tasks stopped by the cancellation above didn't fail on their own
+				if !errors.Is(err, ErrInterrupted) {
+					errs = append(errs, err)
+				}
+			}
+			return errs
+		}
+	}
+
+	return i.Wait()
+}
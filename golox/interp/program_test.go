@@ -0,0 +1,186 @@
+package interp
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+const counterSource = `
+var count = 0;
+var text = "";
+// A channel with room for a single value serves as a lock, the task holding it has sent the value
+var lock = channel(1);
+fun add(n) {
+	for (var i = 0; i < n; i++) {
+		lock.send(true);
+		count++;
+		text = text + "x";
+		lock.receive();
+	}
+}
+var task = spawn add(50);
+add(50);
+await task;
+// Every run has its own globals, so other runs can't interfere
+if (count != 100) throw "globals are shared between runs or updates were lost";
+if (text == "") throw "text was not built";
+`
+
+func mustCompile(tb testing.TB, source string) *Program {
+	tb.Helper()
+
+	program, errs := Compile(source)
+	if errs != nil {
+		tb.Fatal(errs)
+	}
+	return program
+}
+
+// Compiles and runs source with an interpreter that setup has instrumented, e.g. with a profiler
+func mustRunWith(tb testing.TB, source string, setup func(program *Program, interpreter *Interpreter)) {
+	tb.Helper()
+
+	program := mustCompile(tb, source)
+	interpreter := program.NewInterpreter()
+	setup(program, &interpreter)
+	errs := interpreter.Run(context.Background(), program)
+	if errs != nil {
+		tb.Fatal(errs)
+	}
+}
+
+func TestProgramParallelRuns(t *testing.T) {
+	program := mustCompile(t, counterSource)
+
+	var wg sync.WaitGroup
+	errs := make([][]error, 16)
+	for idx := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				interpreter := program.NewInterpreter()
+				errs[idx] = interpreter.Run(context.Background(), program)
+				if errs[idx] != nil {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Tasks that are still running when a statement fails must not be leaked by the run, and those that failed
+// before must still be reported. Maps the programs to the number of errors their runs return.
+func TestProgramFailedRunStopsTasks(t *testing.T) {
+	tests := map[string]int{
+		`var c = channel(0);
+		fun block() { c.receive(); }
+		fun spin() { while (true) {} }
+		spawn block();
+		spawn spin();
+		throw "failed";`: 1,
+		// The statement fails with a deadlock once the task has failed
+		`fun fail() { throw "task failed"; }
+		spawn fail();
+		channel(0).receive();`: 2,
+	}
+
+	goroutines := runtime.NumGoroutine()
+	for source, count := range tests {
+		program := mustCompile(t, source)
+		for range 10 {
+			interpreter := program.NewInterpreter()
+			errs := interpreter.Run(context.Background(), program)
+			if len(errs) != count {
+				t.Fatalf("%s\nexpected %d errors, got %v", source, count, errs)
+			}
+		}
+	}
+
+	// Tasks finish before Wait() returns, but their goroutines may take a moment to exit afterwards
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if runtime.NumGoroutine() > goroutines {
+		t.Fatalf("%d goroutines are still running after the runs, expected %d", runtime.NumGoroutine(), goroutines)
+	}
+}
+
+const concatSource = `
+var s = "con" + "cat";
+if (s != "concat") throw "concatenation differs from constant";
+var other = "run" + "time";
+`
+
+func TestProgramIsolatedStrings(t *testing.T) {
+	program := mustCompile(t, concatSource)
+	compiledSize := program.strings.Size()
+
+	interpreter := program.NewInterpreter()
+	if errs := interpreter.Run(context.Background(), program); errs != nil {
+		t.Fatal(errs)
+	}
+
+	// Strings created at runtime aren't interned, neither in the program's table nor in the interpreter's
+	if interpreter.Strings().Size() != 0 {
+		t.Fatalf("expected an empty string table, got %d bytes", interpreter.Strings().Size())
+	}
+	if program.strings.Size() != compiledSize {
+		t.Fatal("running the program modified its string table")
+	}
+}
+
+// Builds a string of 4000 characters one at a time, creating 8 MB of intermediate strings in total
+const growingStringSource = `
+var s = "";
+for (var i = 0; i < 4000; i++) {
+  s = s + "x";
+}
+var t = s + s;
+`
+
+// Only strings that are still stored in variables count towards the memory limit
+func TestMemoryLimitCountsLiveStrings(t *testing.T) {
+	program := mustCompile(t, growingStringSource)
+
+	interpreter := program.NewInterpreter()
+	interpreter.SetLimits(Limits{MaxMemory: 16_000})
+	if errs := interpreter.Run(context.Background(), program); errs != nil {
+		t.Fatal(errs)
+	}
+
+	interpreter = program.NewInterpreter()
+	interpreter.SetLimits(Limits{MaxMemory: 10_000})
+	errs := interpreter.Run(context.Background(), program)
+	if len(errs) != 1 || !errors.Is(errs[0], ErrMemoryLimit) {
+		t.Fatalf("expected the memory limit to be exceeded by t, got %v", errs)
+	}
+}
+
+func BenchmarkProgramParallel(b *testing.B) {
+	program := mustCompile(b, fibSource)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			interpreter := program.NewInterpreter()
+			errs := interpreter.Run(context.Background(), program)
+			if errs != nil {
+				b.Fatal(errs)
+			}
+		}
+	})
+}
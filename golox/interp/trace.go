@@ -0,0 +1,141 @@
+package interp
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"toterich/golox/ast"
+	"toterich/golox/util"
+)
+
+// Receives the events of a program's execution, see SetTracer(). The events of tasks are reported concurrently,
+// so a Tracer needs to be safe for concurrent use if the program spawns any.
+type Tracer interface {
+	// Called before a statement is executed
+	Statement(frame TraceFrame, stmt ast.Stmt)
+	// Called when a function is called from the given line, before its body is executed
+	Call(frame TraceFrame, arguments []ast.LoxValue, line int)
+	// Called when a function called from the given line returns value, or fails with err
+	Return(frame TraceFrame, value ast.LoxValue, err error, line int)
+	// Called when a variable is declared or assigned to at the given line
+	Assign(frame TraceFrame, name string, value ast.LoxValue, line int)
+}
+
+// The function call an event occurs in. Call and Return events occur in the call of the function being called.
+type TraceFrame struct {
+	Function string // The function's name, <script> at the top level
+	Depth    int    // Number of active calls, 0 at the top level
+}
+
+// Makes the interpreter report its execution to tracer, or stops reporting if tracer is nil. Tasks spawned by
+// the interpreter report to the same tracer.
+func (i *Interpreter) SetTracer(tracer Tracer) {
+	i.tracer = tracer
+}
+
+func (i *Interpreter) traceFrame() TraceFrame {
+	if i.function == "" {
+		return TraceFrame{Function: scriptFunction, Depth: i.depth}
+	}
+	return TraceFrame{Function: i.function, Depth: i.depth}
+}
+
+func (i *Interpreter) traceCall(callee *ast.LoxFunction, arguments []ast.LoxValue, location ast.Token) (ast.LoxValue, error) {
+	caller := i.function
+	i.function = functionName(callee)
+	defer func() { i.function = caller }()
+
+	// Native functions don't increase the depth, but their calls are nested all the same
+	frame := TraceFrame{Function: i.function, Depth: i.depth + 1}
+	i.tracer.Call(frame, arguments, location.Line)
+	value, err := i.invoke(callee, arguments, location)
+	i.tracer.Return(frame, value, err, location.Line)
+	return value, err
+}
+
+// A Tracer that writes each event as a line of text, indented by the depth of its call. Statements are shown
+// as the source line they start at.
+type TraceWriter struct {
+	// Only the events of these functions are written, or of all functions if Functions is empty
+	Functions []string
+	// Only the events at lines from FirstLine to LastLine are written. Either bound is ignored if it is 0.
+	FirstLine, LastLine int
+
+	mu    sync.Mutex
+	w     io.Writer
+	lines []string // Of the source, the first line at index 0
+	err   error
+}
+
+// Creates a TraceWriter that writes to w, for the program compiled from source
+func NewTraceWriter(w io.Writer, source string) *TraceWriter {
+	return &TraceWriter{w: w, lines: strings.Split(source, "\n")}
+}
+
+// Returns the first error that occurred while writing the trace
+func (t *TraceWriter) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+func (t *TraceWriter) Statement(frame TraceFrame, stmt ast.Stmt) {
+	line := stmt.StartLine()
+	text := ""
+	if line > 0 && line <= len(t.lines) {
+		text = strings.TrimSpace(t.lines[line-1])
+	}
+	t.write(frame, line, text)
+}
+
+func (t *TraceWriter) Call(frame TraceFrame, arguments []ast.LoxValue, line int) {
+	values := make([]string, len(arguments))
+	for idx, argument := range arguments {
+		values[idx] = traceValue(argument)
+	}
+	t.write(frame, line, fmt.Sprintf("call %s(%s)", frame.Function, strings.Join(values, ", ")))
+}
+
+func (t *TraceWriter) Return(frame TraceFrame, value ast.LoxValue, err error, line int) {
+	switch err := err.(type) {
+	case nil:
+		t.write(frame, line, fmt.Sprintf("%s returned %s", frame.Function, traceValue(value)))
+	case util.LoxException:
+		t.write(frame, line, fmt.Sprintf("%s threw %s", frame.Function, traceValue(err.Value)))
+	case util.RuntimeError:
+		t.write(frame, line, fmt.Sprintf("%s failed: %s", frame.Function, err.Msg))
+	default:
+		t.write(frame, line, fmt.Sprintf("%s failed: %s", frame.Function, err))
+	}
+}
+
+func (t *TraceWriter) Assign(frame TraceFrame, name string, value ast.LoxValue, line int) {
+	t.write(frame, line, fmt.Sprintf("%s = %s", name, traceValue(value)))
+}
+
+func (t *TraceWriter) write(frame TraceFrame, line int, text string) {
+	if len(t.Functions) > 0 && !slices.Contains(t.Functions, frame.Function) {
+		return
+	}
+	if (t.FirstLine > 0 && line < t.FirstLine) || (t.LastLine > 0 && line > t.LastLine) {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return
+	}
+	_, t.err = fmt.Fprintf(t.w, "%s%s:%d: %s\n", strings.Repeat("  ", frame.Depth), frame.Function, line, text)
+}
+
+// Formats a value for the trace, in which strings are quoted to tell them apart from other values
+func traceValue(value ast.LoxValue) string {
+	if value.Type == ast.LT_STRING {
+		return strconv.Quote(value.AsString())
+	}
+	return value.String()
+}
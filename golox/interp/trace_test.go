@@ -0,0 +1,74 @@
+package interp
+
+import (
+	"bytes"
+	"testing"
+)
+
+const tracedSource = `fun half(n) {
+  if (n < 0) throw "negative";
+  return n / 2;
+}
+var x = half(4);
+x += 1;
+x++;
+try {
+  half(-1);
+} catch (e) {}
+`
+
+func traceProgram(t *testing.T, tracer *TraceWriter) {
+	t.Helper()
+
+	mustRunWith(t, tracedSource, func(_ *Program, interpreter *Interpreter) {
+		interpreter.SetTracer(tracer)
+	})
+	if tracer.Err() != nil {
+		t.Fatal(tracer.Err())
+	}
+}
+
+func TestTrace(t *testing.T) {
+	var out bytes.Buffer
+	traceProgram(t, NewTraceWriter(&out, tracedSource))
+
+	expected := `<script>:1: fun half(n) {
+<script>:5: var x = half(4);
+  half:5: call half(4)
+  half:2: if (n < 0) throw "negative";
+  half:3: return n / 2;
+  half:5: half returned 2
+<script>:5: x = 2
+<script>:6: x += 1;
+<script>:6: x = 3
+<script>:7: x++;
+<script>:7: x = 4
+<script>:8: try {
+<script>:8: try {
+<script>:9: half(-1);
+  half:9: call half(-1)
+  half:2: if (n < 0) throw "negative";
+  half:2: if (n < 0) throw "negative";
+  half:9: half threw "negative"
+<script>:10: } catch (e) {}
+`
+	if out.String() != expected {
+		t.Errorf("expected trace\n%s\ngot\n%s", expected, out.String())
+	}
+}
+
+func TestTraceFilters(t *testing.T) {
+	var out bytes.Buffer
+	tracer := NewTraceWriter(&out, tracedSource)
+	tracer.Functions = []string{"half"}
+	tracer.FirstLine, tracer.LastLine = 3, 5
+	traceProgram(t, tracer)
+
+	expected := `  half:5: call half(4)
+  half:3: return n / 2;
+  half:5: half returned 2
+`
+	if out.String() != expected {
+		t.Errorf("expected trace\n%s\ngot\n%s", expected, out.String())
+	}
+}
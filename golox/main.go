@@ -2,17 +2,50 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math/rand/v2"
 	"os"
+	"strconv"
+	"strings"
+	"toterich/golox/ast"
+	"toterich/golox/conformance"
+	"toterich/golox/grammar"
 	"toterich/golox/interp"
 	"toterich/golox/parse"
+	"toterich/golox/typecheck"
 	"toterich/golox/util"
 )
 
-var scanner parse.Scanner
-var parser parse.Parser
-var interpreter interp.Interpreter
+const usage = `Usage:
+  golox [script.lox]                Run a script, or start a REPL if none is given
+  golox run [flags] script.lox      Run a script
+      --check                       Type check the script before running it
+      --max-steps N                 Abort after executing N statements
+      --max-depth N                 Abort when functions calls are nested more than N deep (default 10000)
+      --max-memory N                Abort when variables and strings use more than about N bytes
+      --timeout DURATION            Abort when the script runs longer than DURATION, e.g. 10s
+      --profile FILE                Write a profile of the time spent in each function and line to FILE, which
+                                    can be viewed with 'go tool pprof FILE'
+      --coverage FILE               Write a report of the statements and branches that have been executed to FILE
+      --coverage-format FORMAT      Format of the coverage report: text (default), lcov or html
+      --trace                       Print each executed statement, function call and assignment to stderr
+      --trace-out FILE              Write the trace to FILE instead of stderr
+      --trace-func NAMES            Only trace the calls of the comma-separated functions, <script> for the
+                                    top level
+      --trace-lines N-M             Only trace the events at lines N to M, either of which may be omitted
+  golox check script.lox            Type check a script without running it
+  golox ast [flags] script.lox      Print the syntax tree the parser builds for a script
+      --format FORMAT               sexpr (default) or json, which can be decoded with ast.DecodeJSON()
+  golox test path...                Run scripts, or all scripts in directories, and compare them against the
+                                    expectations annotated in them
+  golox gen [flags] grammar.txt     Print a random program generated from a grammar, like lox_spec/grammar.txt
+      --seed N                      Generate the program determined by N instead of a random one
+      --max-depth N                 Nest grammar rules about N deep at most (default 40)`
 
 // Check for error and exit
 // If exitCode is 0, only log error and don't exit
@@ -25,63 +58,385 @@ func check(e error, exitCode int) {
 	}
 }
 
-func run(data string) error {
-	tokens, errs := scanner.ScanTokens(data)
-	//	fmt.Println(tokens)
+func compile(data string, withTypeCheck bool) (*interp.Program, error) {
+	program, errs := interp.Compile(data)
 	if errs != nil {
 		util.LogErrors(errs...)
-		return fmt.Errorf("errors in Scanner")
+		return nil, fmt.Errorf("errors in Parser")
+	}
+
+	if withTypeCheck {
+		_, errs = typecheck.Check(program.Ast())
+		if errs != nil {
+			util.LogErrors(errs...)
+			return nil, fmt.Errorf("errors in Type Checker")
+		}
+	}
+
+	return program, nil
+}
+
+type runOptions struct {
+	withTypeCheck  bool          // Only execute the program if it passes the type checker
+	limits         interp.Limits // Limits on the resources the program may use
+	profile        string        // Where to write a profile of the program to, no profile is written if empty
+	coverage       string        // Where to write a coverage report to, no report is written if empty
+	coverageFormat string        // One of text, lcov and html
+	trace          *traceOptions // Whether and how to trace execution, no trace is written if nil
+}
+
+type traceOptions struct {
+	file      string   // Where to write the trace to, stderr if empty
+	functions []string // Only trace these functions, all if empty
+	firstLine int      // Only trace from this line on, 0 for the first line
+	lastLine  int      // Only trace up to this line, 0 for the last line
+}
+
+// Runs the source code of the given file until it finishes or ctx is done
+func run(ctx context.Context, file string, data string, options runOptions) error {
+	program, err := compile(data, options.withTypeCheck)
+	if err != nil {
+		return err
+	}
+
+	interpreter := program.NewInterpreter()
+	interpreter.SetLimits(options.limits)
+
+	var profiler *interp.Profiler
+	if options.profile != "" {
+		profiler = interp.NewProfiler(file)
+		interpreter.SetProfiler(profiler)
 	}
 
-	stmts, errs := parser.Parse(tokens)
+	var coverage *interp.Coverage
+	if options.coverage != "" {
+		coverage = interp.NewCoverage(file, data, program)
+		interpreter.SetCoverage(coverage)
+	}
+
+	var tracer *interp.TraceWriter
+	if options.trace != nil {
+		out := os.Stderr
+		if options.trace.file != "" {
+			out, err = os.Create(options.trace.file)
+			if err != nil {
+				return err
+			}
+			defer out.Close()
+		}
+		tracer = interp.NewTraceWriter(out, data)
+		tracer.Functions = options.trace.functions
+		tracer.FirstLine, tracer.LastLine = options.trace.firstLine, options.trace.lastLine
+		interpreter.SetTracer(tracer)
+	}
+
+	errs := interpreter.Run(ctx, program)
 	if errs != nil {
 		util.LogErrors(errs...)
-		return fmt.Errorf("errors in Parser")
 	}
 
-	for _, stmt := range stmts {
-		err := interpreter.Execute(stmt)
+	// Failing to write one of the outputs doesn't keep the others from being written
+	var failures []error
+	if tracer != nil && tracer.Err() != nil {
+		failures = append(failures, fmt.Errorf("failed to write trace: %w", tracer.Err()))
+	}
+
+	// The profile and coverage are also written if the program fails, which might be caused by running out of time
+	if profiler != nil {
+		err = writeFile(options.profile, profiler.WriteProfile)
 		if err != nil {
-			util.LogErrors(err)
-			return fmt.Errorf("error in Interpreter")
+			failures = append(failures, err)
+		}
+	}
+	if coverage != nil {
+		write := map[string]func(w io.Writer) error{
+			"text": coverage.WriteText,
+			"lcov": coverage.WriteLCOV,
+			"html": coverage.WriteHTML,
+		}[options.coverageFormat]
+		err = writeFile(options.coverage, write)
+		if err != nil {
+			failures = append(failures, err)
 		}
 	}
 
-	return nil
+	if errs != nil {
+		failures = append(failures, fmt.Errorf("error in Interpreter"))
+	}
+
+	return errors.Join(failures...)
+}
+
+// Creates file and writes its content with write
+func writeFile(file string, write func(w io.Writer) error) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
-func runFile(file string) {
+func runFile(ctx context.Context, file string, options runOptions) {
 	data, err := os.ReadFile(file)
 	check(err, 1)
-	err = run(string(data))
+	err = run(ctx, file, string(data), options)
 	check(err, 2)
 }
 
+func checkFile(file string) {
+	data, err := os.ReadFile(file)
+	check(err, 1)
+	_, err = compile(string(data), true)
+	check(err, 2)
+}
+
+// Executes a line entered in the REPL. Unlike a program, all lines share the global scope.
+func runLine(interpreter *interp.Interpreter, scanner *parse.Scanner, line string) error {
+	tokens, errs := scanner.ScanTokens(line)
+	if errs != nil {
+		util.LogErrors(errs...)
+		return fmt.Errorf("errors in Scanner")
+	}
+
+	var parser parse.Parser
+	tree, errs := parser.Parse(tokens)
+	if errs != nil {
+		util.LogErrors(errs...)
+		return fmt.Errorf("errors in Parser")
+	}
+
+	for _, stmt := range tree.Body {
+		err := interpreter.Execute(tree, stmt)
+		if err != nil {
+			util.LogErrors(err)
+			return fmt.Errorf("error in Interpreter")
+		}
+	}
+
+	errs = interpreter.Wait()
+	if errs != nil {
+		util.LogErrors(errs...)
+		return fmt.Errorf("errors in spawned tasks")
+	}
+
+	return nil
+}
+
 func runPrompt() {
 	reader := bufio.NewReader(os.Stdin)
+	interpreter := interp.NewInterpreter()
+	scanner := parse.NewScanner(interpreter.Strings())
 
 	for {
 		fmt.Print("> ")
 		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			fmt.Println()
+			return
+		}
 		check(err, 0)
-		err = run(line)
+		err = runLine(&interpreter, &scanner, line)
 		check(err, 0)
 	}
 }
 
+// Prints the statements the parser creates for the script in file, in the given format
+func printAst(file string, format string) {
+	data, err := os.ReadFile(file)
+	check(err, 1)
+	program, err := compile(string(data), false)
+	check(err, 2)
+
+	if format == "json" {
+		encoded, err := ast.EncodeJSON(program.Ast())
+		check(err, 2)
+		fmt.Println(string(encoded))
+	} else {
+		fmt.Print(ast.FormatSExpr(program.Ast()))
+	}
+}
+
+// Runs the conformance tests in the given files and directories and exits with an error if any of them fail
+func testFiles(paths []string) {
+	var results []conformance.Result
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		check(err, 1)
+
+		if info.IsDir() {
+			dirResults, err := conformance.RunDir(path)
+			check(err, 1)
+			results = append(results, dirResults...)
+		} else {
+			results = append(results, conformance.RunFile(path))
+		}
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Passed() {
+			fmt.Printf("PASS %s\n", result.File)
+			continue
+		}
+
+		failed += 1
+		fmt.Printf("FAIL %s\n", result.File)
+		for _, failure := range result.Failures {
+			fmt.Printf("    %s\n", failure)
+		}
+	}
+
+	fmt.Printf("%d passed, %d failed\n", len(results)-failed, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// Prints a random Lox program generated from the grammar in file, preceded by the seed that reproduces it
+func generateProgram(file string, seed uint64, maxDepth int) {
+	data, err := os.ReadFile(file)
+	check(err, 1)
+	lox, err := grammar.Parse(string(data))
+	check(err, 1)
+
+	generator := grammar.NewLoxGenerator(lox, seed)
+	generator.MaxDepth = maxDepth
+	program, err := generator.Generate()
+	check(err, 2)
+
+	fmt.Printf("// seed: %d\n%s", seed, program)
+}
+
+func parseTraceOptions(file string, functions string, lines string) *traceOptions {
+	options := &traceOptions{file: file}
+	if functions != "" {
+		options.functions = strings.Split(functions, ",")
+	}
+	if lines != "" {
+		first, last, isRange := strings.Cut(lines, "-")
+		var err error
+		if first != "" {
+			options.firstLine, err = strconv.Atoi(first)
+			if err != nil {
+				exitWithUsage()
+			}
+		}
+		if !isRange {
+			options.lastLine = options.firstLine
+		} else if last != "" {
+			options.lastLine, err = strconv.Atoi(last)
+			if err != nil {
+				exitWithUsage()
+			}
+		}
+	}
+	return options
+}
+
+func exitWithUsage() {
+	fmt.Println(usage)
+	os.Exit(64)
+}
+
+// Parses the flags of a subcommand, which takes a single script as its only positional argument. The flags may
+// come before or after the script.
+func parseCommand(flags *flag.FlagSet, args []string) string {
+	flags.Usage = exitWithUsage
+	var positional []string
+	for {
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+	if len(positional) != 1 {
+		exitWithUsage()
+	}
+	return positional[0]
+}
+
 func main() {
 	args := os.Args[1:]
-	if len(args) > 1 {
-		fmt.Println("Usage: golox [script.lox]")
-		os.Exit(64)
 
+	if len(args) == 0 {
+		runPrompt()
+		return
 	}
 
-	interpreter = interp.NewInterpreter()
+	switch args[0] {
+	case "run":
+		flags := flag.NewFlagSet("run", flag.ExitOnError)
+		withTypeCheck := flags.Bool("check", false, "type check the script before running it")
+		maxSteps := flags.Int("max-steps", 0, "maximum number of statements executed, 0 for unlimited")
+		maxDepth := flags.Int("max-depth", interp.DefaultMaxCallDepth, "maximum call depth, 0 for unlimited")
+		maxMemory := flags.Int("max-memory", 0, "approximate maximum memory in bytes, 0 for unlimited")
+		timeout := flags.Duration("timeout", 0, "maximum running time, 0 for unlimited")
+		profile := flags.String("profile", "", "file to write a profile to")
+		coverage := flags.String("coverage", "", "file to write a coverage report to")
+		coverageFormat := flags.String("coverage-format", "text", "format of the coverage report: text, lcov or html")
+		trace := flags.Bool("trace", false, "print each executed statement, call and assignment")
+		traceOut := flags.String("trace-out", "", "file to write the trace to")
+		traceFunc := flags.String("trace-func", "", "comma-separated functions to trace")
+		traceLines := flags.String("trace-lines", "", "range of lines to trace, N-M")
+		file := parseCommand(flags, args[1:])
+		if *coverageFormat != "text" && *coverageFormat != "lcov" && *coverageFormat != "html" {
+			exitWithUsage()
+		}
+		options := runOptions{
+			withTypeCheck:  *withTypeCheck,
+			limits:         interp.Limits{MaxSteps: *maxSteps, MaxCallDepth: *maxDepth, MaxMemory: *maxMemory},
+			profile:        *profile,
+			coverage:       *coverage,
+			coverageFormat: *coverageFormat,
+		}
+		// Any of the trace flags turns on tracing
+		if *trace || *traceOut != "" || *traceFunc != "" || *traceLines != "" {
+			options.trace = parseTraceOptions(*traceOut, *traceFunc, *traceLines)
+		}
 
-	if len(args) == 1 {
-		runFile(args[0])
-	} else {
-		runPrompt()
+		ctx := context.Background()
+		if *timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *timeout)
+			defer cancel()
+		}
+		runFile(ctx, file, options)
+	case "test":
+		flags := flag.NewFlagSet("test", flag.ExitOnError)
+		flags.Usage = exitWithUsage
+		flags.Parse(args[1:])
+		if flags.NArg() == 0 {
+			exitWithUsage()
+		}
+		testFiles(flags.Args())
+	case "check":
+		file := parseCommand(flag.NewFlagSet("check", flag.ExitOnError), args[1:])
+		checkFile(file)
+	case "ast":
+		flags := flag.NewFlagSet("ast", flag.ExitOnError)
+		format := flags.String("format", "sexpr", "output format: sexpr or json")
+		file := parseCommand(flags, args[1:])
+		if *format != "sexpr" && *format != "json" {
+			exitWithUsage()
+		}
+		printAst(file, *format)
+	case "gen":
+		flags := flag.NewFlagSet("gen", flag.ExitOnError)
+		seed := flags.Uint64("seed", rand.Uint64(), "seed of the generated program")
+		maxDepth := flags.Int("max-depth", grammar.DefaultMaxDepth, "maximal depth of nested grammar rules")
+		file := parseCommand(flags, args[1:])
+		generateProgram(file, *seed, *maxDepth)
+	default:
+		if len(args) > 1 {
+			exitWithUsage()
+		}
+		runFile(context.Background(), args[0], runOptions{limits: interp.Limits{MaxCallDepth: interp.DefaultMaxCallDepth}})
 	}
 }
@@ -0,0 +1,44 @@
+package parse
+
+import (
+	"testing"
+	"toterich/golox/ast"
+	"toterich/golox/util/fuzzseed"
+)
+
+// The sample scripts and spec tests, which the fuzz targets start from
+var seedDirs = []string{"../../lox_spec/samples", "../../lox_spec/tests"}
+
+func FuzzScanTokens(f *testing.F) {
+	fuzzseed.AddScripts(f, seedDirs...)
+
+	f.Fuzz(func(t *testing.T, source string) {
+		scanner := NewScanner(ast.NewStringTable())
+		tokens, errs := scanner.ScanTokens(source)
+		if errs == nil && (len(tokens) == 0 || tokens[len(tokens)-1].Type != ast.EOF) {
+			t.Fatalf("tokens don't end with EOF: %v", tokens)
+		}
+	})
+}
+
+func FuzzParse(f *testing.F) {
+	fuzzseed.AddScripts(f, seedDirs...)
+
+	f.Fuzz(func(t *testing.T, source string) {
+		scanner := NewScanner(ast.NewStringTable())
+		tokens, errs := scanner.ScanTokens(source)
+		if errs != nil {
+			return
+		}
+
+		var parser Parser
+		tree, errs := parser.Parse(tokens)
+		if errs == nil {
+			for _, stmt := range tree.Body {
+				if tree.Stmt(stmt) == nil {
+					t.Fatal("parser returned a nil statement without an error")
+				}
+			}
+		}
+	})
+}
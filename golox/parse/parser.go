@@ -1,6 +1,7 @@
 package parse
 
 import (
+	"fmt"
 	"toterich/golox/ast"
 	"toterich/golox/util"
 	"toterich/golox/util/assert"
@@ -8,23 +9,29 @@ import (
 
 // A recursive-descent parser for transforming a stream of Tokens into an AST
 type Parser struct {
-	tokens    []ast.Token
-	errs      []error
-	ast       ast.Ast
-	current   int
-	loopLevel int
+	tokens      []ast.Token
+	errs        []error
+	ast         *ast.Ast
+	current     int
+	loopLevel   int
+	switchLevel int
+	funLevel    int
 }
 
 // For grammar rules, see lox_spec/grammar.txt
 // Every rule is implemented in a parse... function below
 
 // program        -> statement* EOF;
-func (p *Parser) Parse(input []ast.Token) ([]ast.Stmt, []error) {
+// Returns a new Ast for each call, whose stores resolve the IDs of the parsed nodes. Nodes created to add to
+// the Ast, e.g. when rewriting it, need to be allocated by its stores as well.
+func (p *Parser) Parse(input []ast.Token) (*ast.Ast, []error) {
 	p.tokens = input
 	p.errs = nil
-	p.ast = ast.Ast{}
+	p.ast = &ast.Ast{}
 	p.current = 0
 	p.loopLevel = 0
+	p.switchLevel = 0
+	p.funLevel = 0
 
 	for !p.isAtEnd() {
 		stmt, errs := p.parseDeclaration()
@@ -33,11 +40,11 @@ func (p *Parser) Parse(input []ast.Token) ([]ast.Stmt, []error) {
 			p.errs = append(p.errs, errs...)
 			p.skipToNextStatement()
 		} else {
-			p.ast.Body = append(p.ast.Body, stmt)
+			p.ast.Body = append(p.ast.Body, stmt.ID())
 		}
 	}
 
-	return p.ast.Body, p.errs
+	return p.ast, p.errs
 }
 
 // declaration    -> funDecl | varDecl | statement ;
@@ -45,7 +52,10 @@ func (p *Parser) parseDeclaration() (ast.Stmt, []error) {
 	var stmt ast.Stmt
 	var err error
 
-	if p.match(ast.FUN) {
+	// "fun" only starts a declaration if it is followed by the function name. Otherwise, it is the beginning of
+	// an expression statement with an anonymous function.
+	if p.check(ast.FUN) && p.checkNext(ast.IDENTIFIER) {
+		p.match(ast.FUN)
 		return p.parseFunDecl()
 	} else if p.match(ast.VAR) {
 		stmt, err = p.parseVarDecl()
@@ -61,8 +71,7 @@ func (p *Parser) parseDeclaration() (ast.Stmt, []error) {
 }
 
 // funDecl        -> "fun" function ;
-// function       -> IDENTIFIER "(" parameters? ")" blockStmt ;
-// parameters     -> IDENTIFIER ( "," IDENTIFIER )* ;
+// function       -> IDENTIFIER functionBody ;
 func (p *Parser) parseFunDecl() (ast.Stmt, []error) {
 	// Function name
 	name, err := p.consume(ast.IDENTIFIER, "expected identifier after 'fun'.")
@@ -70,90 +79,140 @@ func (p *Parser) parseFunDecl() (ast.Stmt, []error) {
 		return nil, []error{err}
 	}
 
-	_, err = p.consume(ast.LEFT_PAREN, "expected '(' after function name.")
+	function, errs := p.parseFunctionBody()
+	if errs != nil {
+		return nil, errs
+	}
+
+	return p.ast.Statements.NewFunDecl(name.Line, name, function), nil
+}
+
+// functionBody   -> "(" parameters? ")" typeAnnotation? blockStmt ;
+// parameters     -> parameter ( "," parameter )* ;
+// parameter      -> IDENTIFIER typeAnnotation? ;
+// Shared between function declarations and anonymous functions.
+func (p *Parser) parseFunctionBody() (ast.FunctionBody, []error) {
+	var function ast.FunctionBody
+
+	_, err := p.consume(ast.LEFT_PAREN, "expected '(' before function parameters.")
 	if err != nil {
-		return nil, []error{err}
+		return function, []error{err}
 	}
 
 	// Function parameters
-	var params []ast.Token
-
 	funcParseParam := func() error {
-		param, err := p.parsePrimary()
+		param, err := p.consume(ast.IDENTIFIER, "function parameter needs to be an identifier.")
 		if err != nil {
 			return err
 		}
 
-		if param, ok := param.(*ast.IdentifierExpr); ok {
-			params = append(params, param.Token)
-			return nil
-		} else {
-			return util.NewSyntaxError(p.previous(), "function parameter needs to be an identifier.")
+		paramType, err := p.parseOptionalTypeAnnotation()
+		if err != nil {
+			return err
 		}
+
+		function.Params = append(function.Params, param)
+		function.ParamTypes = append(function.ParamTypes, paramType)
+		return nil
 	}
 
 	if !p.match(ast.RIGHT_PAREN) {
 		// first parameter
 		err = funcParseParam()
 		if err != nil {
-			return nil, []error{err}
+			return function, []error{err}
 		}
 
 		// additional parameters
 		for p.match(ast.COMMA) {
 			err = funcParseParam()
 			if err != nil {
-				return nil, []error{err}
+				return function, []error{err}
 			}
 		}
 
 		_, err = p.consume(ast.RIGHT_PAREN, "expected ')' after function parameters.")
 		if err != nil {
-			return nil, []error{err}
+			return function, []error{err}
 		}
 	} // else, there are no parameters
 
+	function.ReturnType, err = p.parseOptionalTypeAnnotation()
+	if err != nil {
+		return function, []error{err}
+	}
+
 	// Function Body
 	_, err = p.consume(ast.LEFT_BRACE, "expected '{' before function body.")
 	if err != nil {
-		return nil, []error{err}
+		return function, []error{err}
 	}
 
+	// Loops and switches surrounding the function can't be broken out of from inside its body
+	loopLevel, switchLevel := p.loopLevel, p.switchLevel
+	p.loopLevel, p.switchLevel = 0, 0
+	p.funLevel += 1
 	body, errs := p.parseBlockStmt()
+	p.funLevel -= 1
+	p.loopLevel, p.switchLevel = loopLevel, switchLevel
+
 	if errs != nil {
-		return body, errs
+		return function, errs
 	}
 
 	if body, ok := body.(*ast.BlockStmt); ok {
-		return p.ast.Statements.NewFunDecl(name, params, body.Body), nil
+		function.Body = body.Body
+		return function, nil
 	} else {
-		panic("FunDecl body should have been a block statement, but wasn't")
+		panic("Function body should have been a block statement, but wasn't")
+	}
+}
+
+// typeAnnotation -> ":" IDENTIFIER ;
+// Returns nil if there is no annotation at the current position.
+func (p *Parser) parseOptionalTypeAnnotation() (*ast.TypeAnnotation, error) {
+	if !p.match(ast.COLON) {
+		return nil, nil
+	}
+
+	name, err := p.consume(ast.IDENTIFIER, "expected type name after ':'.")
+	if err != nil {
+		return nil, err
 	}
+
+	return &ast.TypeAnnotation{Name: name}, nil
 }
 
-// varDeclStmt    -> "var" IDENTIFIER ("=" expression)? ";";
+// varDeclStmt    -> "var" IDENTIFIER typeAnnotation? ("=" expression)? ";";
 func (p *Parser) parseVarDecl() (ast.Stmt, error) {
 	if !p.match(ast.IDENTIFIER) {
 		return nil,
 			util.NewSyntaxError(p.peek(), "expected identifier after 'var'.")
 	}
+	identifier := p.previous()
+
+	type_, err := p.parseOptionalTypeAnnotation()
+	if err != nil {
+		return nil, err
+	}
 
-	stmt := p.ast.Statements.NewVarDecl(p.previous(), nil)
+	stmt := p.ast.Statements.NewVarDecl(identifier.Line, identifier, type_, 0)
 
 	if p.match(ast.EQUAL) {
 		expr, err := p.parseExpression()
 		if err != nil {
 			return nil, err
 		}
-		stmt.Value = expr
+		stmt.Value = expr.ID()
 	}
 
-	_, err := p.consume(ast.SEMICOLON, "expected ; after variable declaration.")
+	_, err = p.consume(ast.SEMICOLON, "expected ; after variable declaration.")
 	return stmt, err
 }
 
 // statement
-// -> exprStmt | ifStmt | printStmt | whileStmt | forStmt | breakStmt | blockStmt ;
+// -> exprStmt | ifStmt | printStmt | whileStmt | forStmt | switchStmt | breakStmt | continueStmt
+// | returnStmt | throwStmt | tryStmt | blockStmt ;
 func (p *Parser) parseStatement() (ast.Stmt, []error) {
 	if p.match(ast.LEFT_BRACE) {
 		return p.parseBlockStmt()
@@ -167,6 +226,12 @@ func (p *Parser) parseStatement() (ast.Stmt, []error) {
 	if p.match(ast.FOR) {
 		return p.parseForStmt()
 	}
+	if p.match(ast.SWITCH) {
+		return p.parseSwitchStmt()
+	}
+	if p.match(ast.TRY) {
+		return p.parseTryStmt()
+	}
 
 	// The following statements can only produce a single error each, which
 	// is packed inside a single-element array
@@ -176,12 +241,23 @@ func (p *Parser) parseStatement() (ast.Stmt, []error) {
 	if p.match(ast.PRINT) {
 		stmt, err = p.parsePrintStmt()
 	} else if p.match(ast.BREAK) {
-		if p.loopLevel < 1 {
-			err = util.NewSyntaxError(p.previous(), "break statement outside of loop.")
+		if p.loopLevel < 1 && p.switchLevel < 1 {
+			err = util.NewSyntaxError(p.previous(), "break statement outside of loop or switch.")
 		} else {
-			stmt = p.ast.Statements.NewBreak()
+			stmt = p.ast.Statements.NewBreak(p.previous().Line)
 			_, err = p.consume(ast.SEMICOLON, "expected ';' after break.")
 		}
+	} else if p.match(ast.CONTINUE) {
+		if p.loopLevel < 1 {
+			err = util.NewSyntaxError(p.previous(), "continue statement outside of loop.")
+		} else {
+			stmt = p.ast.Statements.NewContinue(p.previous().Line)
+			_, err = p.consume(ast.SEMICOLON, "expected ';' after continue.")
+		}
+	} else if p.match(ast.RETURN) {
+		stmt, err = p.parseReturnStmt()
+	} else if p.match(ast.THROW) {
+		stmt, err = p.parseThrowStmt()
 	} else {
 		stmt, err = p.parseExprStmt()
 	}
@@ -197,12 +273,13 @@ func (p *Parser) parseStatement() (ast.Stmt, []error) {
 // parseBlockStmt() can return multiple errors because each nested statement can
 // produce an error
 func (p *Parser) parseBlockStmt() (ast.Stmt, []error) {
-	var body []ast.Stmt
+	line := p.previous().Line
+	var body []ast.StmtID
 	var errs []error
 
 	// Empty blocks are allowed
 	if p.match(ast.RIGHT_BRACE) {
-		return p.ast.Statements.NewBlock(body), errs
+		return p.ast.Statements.NewBlock(line, body), errs
 	}
 
 	for !p.isAtEnd() {
@@ -213,22 +290,24 @@ func (p *Parser) parseBlockStmt() (ast.Stmt, []error) {
 			p.skipToNextStatement()
 			// Check if we skipped over the end of the block
 			if p.previous().Type == ast.RIGHT_BRACE {
-				return p.ast.Statements.NewBlock(body), errs
+				return p.ast.Statements.NewBlock(line, body), errs
 			}
 			continue
 		}
-		body = append(body, stmt)
+		body = append(body, stmt.ID())
 		if p.match(ast.RIGHT_BRACE) {
-			return p.ast.Statements.NewBlock(body), errs
+			return p.ast.Statements.NewBlock(line, body), errs
 		}
 	}
 
 	errs = append(errs, util.NewSyntaxError(p.peek(), "missing closing '}'."))
-	return p.ast.Statements.NewBlock(body), errs
+	return p.ast.Statements.NewBlock(line, body), errs
 }
 
 // ifStmt         -> "if" "(" expression ")" statement ("else" statement)? ;
 func (p *Parser) parseIfStmt() (ast.Stmt, []error) {
+	line := p.previous().Line
+
 	if !p.match(ast.LEFT_PAREN) {
 		return nil,
 			[]error{util.NewSyntaxError(p.peek(), "expected condition after 'if'.")}
@@ -258,11 +337,12 @@ func (p *Parser) parseIfStmt() (ast.Stmt, []error) {
 		}
 	}
 
-	return p.ast.Statements.NewIf(condition, ifStmt, elseStmt), nil
+	return p.ast.Statements.NewIf(line, condition.ID(), ifStmt.ID(), optionalStmt(elseStmt)), nil
 }
 
 // whileStmt      -> "while" "(" expression ")" statement ;
 func (p *Parser) parseWhileStmt() (ast.Stmt, []error) {
+	line := p.previous().Line
 	p.incLoopLevel()
 	defer p.decLoopLevel()
 
@@ -286,11 +366,12 @@ func (p *Parser) parseWhileStmt() (ast.Stmt, []error) {
 		return loopStmt, errs
 	}
 
-	return p.ast.Statements.NewWhile(condition, loopStmt), nil
+	return p.ast.Statements.NewWhile(line, condition.ID(), loopStmt.ID(), 0), nil
 }
 
 // forStmt        -> "for" "(" (varDeclStmt | exprStmt | ";" ) expression? ";" expression? ")" statement ;
 func (p *Parser) parseForStmt() (ast.Stmt, []error) {
+	line := p.previous().Line
 	p.incLoopLevel()
 	defer p.decLoopLevel()
 
@@ -349,46 +430,253 @@ func (p *Parser) parseForStmt() (ast.Stmt, []error) {
 		return body, errs
 	}
 
-	// Desugar the for loop to a while statement by adding the initializer and increment as
-	// statements
-	while := p.ast.Statements.NewWhile(condition, body)
-
-	if increment != nil {
-		// If the loop body is already a block statement, append the increment to the end, otherwise create
-		// a block statement of the original body and the increment
-		if then, ok := while.Then.(*ast.BlockStmt); ok {
-			then.Body = append(then.Body, p.ast.Statements.NewExpr(increment))
-		} else {
-			while.Then = p.ast.Statements.NewBlock([]ast.Stmt{while.Then, p.ast.Statements.NewExpr(increment)})
-		}
-	}
+	// Desugar the for loop to a while statement. The increment is kept as part of the loop rather than
+	// appended to the body, so that a continue statement in the body doesn't skip it.
+	while := p.ast.Statements.NewWhile(line, condition.ID(), body.ID(), optionalExpr(increment))
 
 	if initializer != nil {
 		// Wrap the whole while statement in a block and prepend the initializer
-		return p.ast.Statements.NewBlock([]ast.Stmt{initializer, while}), nil
+		return p.ast.Statements.NewBlock(line, []ast.StmtID{initializer.ID(), while.ID()}), nil
 	} else {
 		return while, nil
 	}
 }
 
+// switchStmt     -> "switch" "(" expression ")" "{" switchCase* defaultCase? switchCase* "}" ;
+// switchCase     -> "case" literal ( "," literal )* ":" declaration* ;
+// defaultCase    -> "default" ":" declaration* ;
+func (p *Parser) parseSwitchStmt() (ast.Stmt, []error) {
+	p.switchLevel += 1
+	defer func() { p.switchLevel -= 1 }()
+
+	keyword := p.previous()
+
+	_, err := p.consume(ast.LEFT_PAREN, "expected '(' after 'switch'.")
+	if err != nil {
+		return nil, []error{err}
+	}
+	value, err := p.parseExpression()
+	if err != nil {
+		return nil, []error{err}
+	}
+	_, err = p.consume(ast.RIGHT_PAREN, "expected ')' after 'switch' value.")
+	if err != nil {
+		return nil, []error{err}
+	}
+	_, err = p.consume(ast.LEFT_BRACE, "expected '{' before 'switch' body.")
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	var cases []ast.SwitchCase
+	var default_ ast.Stmt
+	var seen []ast.LoxValue
+
+	for !p.match(ast.RIGHT_BRACE) {
+		if p.match(ast.CASE) {
+			var values []ast.ExprID
+			for {
+				literal, err := p.parseCaseLiteral()
+				if err != nil {
+					return nil, []error{err}
+				}
+				for _, other := range seen {
+					if other.IsEqual(literal.Token.Literal) {
+						return nil, []error{util.NewSyntaxError(literal.Token, "duplicate case value.")}
+					}
+				}
+				seen = append(seen, literal.Token.Literal)
+				values = append(values, literal.ID())
+
+				if !p.match(ast.COMMA) {
+					break
+				}
+			}
+			_, err = p.consume(ast.COLON, "expected ':' after case values.")
+			if err != nil {
+				return nil, []error{err}
+			}
+
+			body, errs := p.parseCaseBody()
+			if errs != nil {
+				return nil, errs
+			}
+			cases = append(cases, ast.SwitchCase{Values: values, Body: body.ID()})
+		} else if p.match(ast.DEFAULT) {
+			if default_ != nil {
+				return nil, []error{util.NewSyntaxError(p.previous(), "multiple default cases in switch.")}
+			}
+			_, err = p.consume(ast.COLON, "expected ':' after 'default'.")
+			if err != nil {
+				return nil, []error{err}
+			}
+
+			body, errs := p.parseCaseBody()
+			if errs != nil {
+				return nil, errs
+			}
+			default_ = body
+		} else {
+			return nil, []error{util.NewSyntaxError(p.peek(), "expected 'case', 'default' or '}' in switch.")}
+		}
+	}
+
+	return p.ast.Statements.NewSwitch(keyword.Line, keyword, value.ID(), cases, optionalStmt(default_)), nil
+}
+
+// literal        -> "-"? NUMBER | STRING | "true" | "false" | "nil" ;
+func (p *Parser) parseCaseLiteral() (*ast.LiteralExpr, error) {
+	if p.match(ast.MINUS) {
+		minus := p.previous()
+		number, err := p.consume(ast.NUMBER, "expected number after '-' in case value.")
+		if err != nil {
+			return nil, err
+		}
+		// Fold the sign into the literal, case values are constants
+		number.Lexeme = minus.Lexeme + number.Lexeme
+		number.Literal = ast.NewNumberValue(-number.Literal.AsNumber())
+		return p.ast.Expressions.NewLiteralExpr(number), nil
+	}
+
+	if p.match(ast.NUMBER, ast.STRING, ast.TRUE, ast.FALSE, ast.NIL) {
+		return p.ast.Expressions.NewLiteralExpr(p.previous()), nil
+	}
+
+	return nil, util.NewSyntaxError(p.peek(), "case value must be a literal.")
+}
+
+// Parses the statements of a switch case up to the next case, default or the end of the switch
+func (p *Parser) parseCaseBody() (ast.Stmt, []error) {
+	line := p.previous().Line
+	var body []ast.StmtID
+
+	for !p.check(ast.CASE) && !p.check(ast.DEFAULT) && !p.check(ast.RIGHT_BRACE) {
+		if p.isAtEnd() {
+			return nil, []error{util.NewSyntaxError(p.peek(), "missing closing '}' after switch.")}
+		}
+
+		stmt, errs := p.parseDeclaration()
+		if errs != nil {
+			return nil, errs
+		}
+		body = append(body, stmt.ID())
+	}
+
+	return p.ast.Statements.NewBlock(line, body), nil
+}
+
+// tryStmt        -> "try" blockStmt ( catchClause ( "finally" blockStmt )? | "finally" blockStmt ) ;
+// catchClause    -> "catch" "(" IDENTIFIER ")" blockStmt ;
+// At least one of the catch and finally clauses is required.
+func (p *Parser) parseTryStmt() (ast.Stmt, []error) {
+	keyword := p.previous()
+
+	_, err := p.consume(ast.LEFT_BRACE, "expected '{' after 'try'.")
+	if err != nil {
+		return nil, []error{err}
+	}
+	body, errs := p.parseBlockStmt()
+	if errs != nil {
+		return body, errs
+	}
+
+	var catchName ast.Token
+	var catch ast.Stmt
+	if p.match(ast.CATCH) {
+		_, err = p.consume(ast.LEFT_PAREN, "expected '(' after 'catch'.")
+		if err != nil {
+			return nil, []error{err}
+		}
+		catchName, err = p.consume(ast.IDENTIFIER, "expected identifier in 'catch' clause.")
+		if err != nil {
+			return nil, []error{err}
+		}
+		_, err = p.consume(ast.RIGHT_PAREN, "expected ')' after 'catch' identifier.")
+		if err != nil {
+			return nil, []error{err}
+		}
+		_, err = p.consume(ast.LEFT_BRACE, "expected '{' before 'catch' body.")
+		if err != nil {
+			return nil, []error{err}
+		}
+		catch, errs = p.parseBlockStmt()
+		if errs != nil {
+			return catch, errs
+		}
+	}
+
+	var finally ast.Stmt
+	if p.match(ast.FINALLY) {
+		_, err = p.consume(ast.LEFT_BRACE, "expected '{' after 'finally'.")
+		if err != nil {
+			return nil, []error{err}
+		}
+		finally, errs = p.parseBlockStmt()
+		if errs != nil {
+			return finally, errs
+		}
+	}
+
+	if catch == nil && finally == nil {
+		return nil, []error{util.NewSyntaxError(keyword, "expected 'catch' or 'finally' after 'try' block.")}
+	}
+
+	return p.ast.Statements.NewTry(keyword.Line, body.ID(), catchName, optionalStmt(catch), optionalStmt(finally)), nil
+}
+
+// returnStmt     -> "return" expression? ";" ;
+func (p *Parser) parseReturnStmt() (ast.Stmt, error) {
+	keyword := p.previous()
+	if p.funLevel < 1 {
+		return nil, util.NewSyntaxError(keyword, "return statement outside of function.")
+	}
+
+	var value ast.Expr
+	if !p.check(ast.SEMICOLON) {
+		var err error
+		value, err = p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err := p.consume(ast.SEMICOLON, "expected ';' after return.")
+	return p.ast.Statements.NewReturn(keyword.Line, keyword, optionalExpr(value)), err
+}
+
+// throwStmt      -> "throw" expression ";" ;
+func (p *Parser) parseThrowStmt() (ast.Stmt, error) {
+	keyword := p.previous()
+
+	value, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = p.consume(ast.SEMICOLON, "expected ';' after throw.")
+	return p.ast.Statements.NewThrow(keyword.Line, keyword, value.ID()), err
+}
+
 // printStmt      -> "print" expression ";"
 func (p *Parser) parsePrintStmt() (ast.Stmt, error) {
+	line := p.previous().Line
 	expr, err := p.parseExpression()
 	if err != nil {
 		return nil, err
 	}
 	_, err = p.consume(ast.SEMICOLON, "expected ; after print statement.")
-	return p.ast.Statements.NewPrint(expr), err
+	return p.ast.Statements.NewPrint(line, expr.ID()), err
 }
 
 // exprStmt       -> expression ";"
 func (p *Parser) parseExprStmt() (ast.Stmt, error) {
+	line := p.peek().Line
 	expr, err := p.parseExpression()
 	if err != nil {
 		return nil, err
 	}
 	_, err = p.consume(ast.SEMICOLON, "expected ; after expression.")
-	return p.ast.Statements.NewExpr(expr), err
+	return p.ast.Statements.NewExpr(line, expr.ID()), err
 }
 
 // expression     -> comma_op
@@ -403,31 +691,34 @@ func (p *Parser) parseCommaOp() (ast.Expr, error) {
 		return expr, err
 	}
 
-	for p.match(ast.COMMA) {
-		operator := p.previous()
+	if !p.check(ast.COMMA) {
+		return expr, nil
+	}
 
+	exprs := []ast.ExprID{expr.ID()}
+	for p.match(ast.COMMA) {
 		right, err := p.parseAssignment()
 		if err != nil {
 			return expr, err
 		}
-		expr = p.ast.Expressions.NewBinaryExpr(operator, expr, right)
+		exprs = append(exprs, right.ID())
 	}
 
-	return expr, nil
+	return p.ast.Expressions.NewSequenceExpr(exprs), nil
 }
 
-// assignment     -> IDENTIFIER "=" assignment | logic_or;
+// assignment     -> IDENTIFIER ( "=" | "+=" | "-=" | "*=" | "/=" ) assignment | conditional;
 func (p *Parser) parseAssignment() (ast.Expr, error) {
 	// We parse the lhs of the assignment first as a general expression and only check if it is a
 	// valid assignment target further below. This allows parsing complex l-values, e.g.
 	// makeInst().foo.bar = val
 
-	expr, err := p.parseLogicOr()
+	expr, err := p.parseConditional()
 	if err != nil {
 		return expr, err
 	}
 
-	if p.match(ast.EQUAL) {
+	if p.match(ast.EQUAL, ast.PLUS_EQUAL, ast.MINUS_EQUAL, ast.STAR_EQUAL, ast.SLASH_EQUAL) {
 		equals := p.previous()
 		right, err := p.parseAssignment()
 		if err != nil {
@@ -435,15 +726,49 @@ func (p *Parser) parseAssignment() (ast.Expr, error) {
 		}
 
 		if expr, ok := expr.(*ast.IdentifierExpr); ok {
-			return p.ast.Expressions.NewAssignExpr(expr.Token, right), nil
+			if equals.Type == ast.EQUAL {
+				return p.ast.Expressions.NewAssignExpr(expr.Token, right.ID()), nil
+			}
+			return p.ast.Expressions.NewCompoundAssignExpr(expr.Token, equals, right.ID()), nil
 		}
 
-		return expr, util.NewRuntimeError(equals, "lhs of assignment is not an identifier.")
+		return expr, util.NewSyntaxError(equals, "lhs of assignment is not an identifier.")
 	}
 
 	return expr, err
 }
 
+// conditional    -> logic_or ( "?" expression ":" conditional )? ;
+func (p *Parser) parseConditional() (ast.Expr, error) {
+	expr, err := p.parseLogicOr()
+	if err != nil {
+		return expr, err
+	}
+
+	if !p.match(ast.QUESTION) {
+		return expr, nil
+	}
+	question := p.previous()
+
+	then, err := p.parseExpression()
+	if err != nil {
+		return then, err
+	}
+
+	_, err = p.consume(ast.COLON, "expected ':' after then branch of conditional expression.")
+	if err != nil {
+		return expr, err
+	}
+
+	// Recursing makes the operator right-associative, so a ? b : c ? d : e is a ? b : (c ? d : e)
+	else_, err := p.parseConditional()
+	if err != nil {
+		return else_, err
+	}
+
+	return p.ast.Expressions.NewConditionalExpr(question, expr.ID(), then.ID(), else_.ID()), nil
+}
+
 // logic_or       -> logic_and ("or" logic_and)* ;
 func (p *Parser) parseLogicOr() (ast.Expr, error) {
 	expr, err := p.parseLogicAnd()
@@ -457,7 +782,7 @@ func (p *Parser) parseLogicOr() (ast.Expr, error) {
 			return right, err
 		}
 
-		expr = p.ast.Expressions.NewOrExpr(expr, right)
+		expr = p.ast.Expressions.NewOrExpr(expr.ID(), right.ID())
 	}
 
 	return expr, nil
@@ -476,7 +801,7 @@ func (p *Parser) parseLogicAnd() (ast.Expr, error) {
 			return right, err
 		}
 
-		expr = p.ast.Expressions.NewAndExpr(expr, right)
+		expr = p.ast.Expressions.NewAndExpr(expr.ID(), right.ID())
 	}
 
 	return expr, nil
@@ -497,15 +822,15 @@ func (p *Parser) parseEquality() (ast.Expr, error) {
 			return expr, err
 		}
 
-		expr = p.ast.Expressions.NewBinaryExpr(operator, expr, right)
+		expr = p.ast.Expressions.NewBinaryExpr(operator, expr.ID(), right.ID())
 	}
 
 	return expr, nil
 }
 
-// comparison     → term ( ( ">" | ">=" | "<" | "<=" ) term )* ;
+// comparison     → bit_or ( ( ">" | ">=" | "<" | "<=" ) bit_or )* ;
 func (p *Parser) parseComparison() (ast.Expr, error) {
-	expr, err := p.parseTerm()
+	expr, err := p.parseBitOr()
 	if err != nil {
 		return nil, err
 	}
@@ -513,12 +838,96 @@ func (p *Parser) parseComparison() (ast.Expr, error) {
 	for p.match(ast.GREATER, ast.GREATER_EQUAL, ast.LESS, ast.LESS_EQUAL) {
 		operator := p.previous()
 
+		right, err := p.parseBitOr()
+		if err != nil {
+			return expr, err
+		}
+
+		expr = p.ast.Expressions.NewBinaryExpr(operator, expr.ID(), right.ID())
+	}
+
+	return expr, nil
+}
+
+// bit_or         → bit_xor ( "|" bit_xor )* ;
+func (p *Parser) parseBitOr() (ast.Expr, error) {
+	expr, err := p.parseBitXor()
+	if err != nil {
+		return expr, err
+	}
+
+	for p.match(ast.PIPE) {
+		operator := p.previous()
+
+		right, err := p.parseBitXor()
+		if err != nil {
+			return expr, err
+		}
+
+		expr = p.ast.Expressions.NewBinaryExpr(operator, expr.ID(), right.ID())
+	}
+
+	return expr, nil
+}
+
+// bit_xor        → bit_and ( "^" bit_and )* ;
+func (p *Parser) parseBitXor() (ast.Expr, error) {
+	expr, err := p.parseBitAnd()
+	if err != nil {
+		return expr, err
+	}
+
+	for p.match(ast.CARET) {
+		operator := p.previous()
+
+		right, err := p.parseBitAnd()
+		if err != nil {
+			return expr, err
+		}
+
+		expr = p.ast.Expressions.NewBinaryExpr(operator, expr.ID(), right.ID())
+	}
+
+	return expr, nil
+}
+
+// bit_and        → shift ( "&" shift )* ;
+func (p *Parser) parseBitAnd() (ast.Expr, error) {
+	expr, err := p.parseShift()
+	if err != nil {
+		return expr, err
+	}
+
+	for p.match(ast.AMPERSAND) {
+		operator := p.previous()
+
+		right, err := p.parseShift()
+		if err != nil {
+			return expr, err
+		}
+
+		expr = p.ast.Expressions.NewBinaryExpr(operator, expr.ID(), right.ID())
+	}
+
+	return expr, nil
+}
+
+// shift          → term ( ( "<<" | ">>" ) term )* ;
+func (p *Parser) parseShift() (ast.Expr, error) {
+	expr, err := p.parseTerm()
+	if err != nil {
+		return expr, err
+	}
+
+	for p.match(ast.LESS_LESS, ast.GREATER_GREATER) {
+		operator := p.previous()
+
 		right, err := p.parseTerm()
 		if err != nil {
 			return expr, err
 		}
 
-		expr = p.ast.Expressions.NewBinaryExpr(operator, expr, right)
+		expr = p.ast.Expressions.NewBinaryExpr(operator, expr.ID(), right.ID())
 	}
 
 	return expr, nil
@@ -539,20 +948,20 @@ func (p *Parser) parseTerm() (ast.Expr, error) {
 			return expr, err
 		}
 
-		expr = p.ast.Expressions.NewBinaryExpr(operator, expr, right)
+		expr = p.ast.Expressions.NewBinaryExpr(operator, expr.ID(), right.ID())
 	}
 
 	return expr, nil
 }
 
-// factor         → unary ( ( "/" | "*" ) unary )* ;
+// factor         → unary ( ( "/" | "*" | "%" | "~/" ) unary )* ;
 func (p *Parser) parseFactor() (ast.Expr, error) {
 	expr, err := p.parseUnary()
 	if err != nil {
 		return expr, err
 	}
 
-	for p.match(ast.SLASH, ast.STAR) {
+	for p.match(ast.SLASH, ast.STAR, ast.PERCENT, ast.TILDE_SLASH) {
 		operator := p.previous()
 
 		right, err := p.parseUnary()
@@ -560,74 +969,162 @@ func (p *Parser) parseFactor() (ast.Expr, error) {
 			return expr, err
 		}
 
-		expr = p.ast.Expressions.NewBinaryExpr(operator, expr, right)
+		expr = p.ast.Expressions.NewBinaryExpr(operator, expr.ID(), right.ID())
 	}
 
 	return expr, nil
 }
 
-// unary          -> ( "!" | "-" ) unary | call ;
+// unary          -> ( "!" | "-" | "~" | "await" ) unary | ( "++" | "--" ) IDENTIFIER | "spawn" call "(" arguments? ")" | power ;
 func (p *Parser) parseUnary() (ast.Expr, error) {
-	if p.match(ast.BANG, ast.MINUS) {
+	if p.match(ast.SPAWN) {
+		keyword := p.previous()
+		expr, err := p.parseCall()
+		if err != nil {
+			return expr, err
+		}
+		call, ok := expr.(*ast.CallExpr)
+		if !ok {
+			return expr, util.NewSyntaxError(keyword, "expected function call after 'spawn'.")
+		}
+		return p.ast.Expressions.NewSpawnExpr(keyword, call.ID()), nil
+	}
+
+	if p.match(ast.AWAIT) {
+		keyword := p.previous()
+		task, err := p.parseUnary()
+		if err != nil {
+			return task, err
+		}
+		return p.ast.Expressions.NewAwaitExpr(keyword, task.ID()), nil
+	}
+
+	if p.match(ast.PLUS_PLUS, ast.MINUS_MINUS) {
+		operator := p.previous()
+		target, err := p.consume(ast.IDENTIFIER, fmt.Sprintf("operand of '%s' is not an identifier.", operator.Lexeme))
+		if err != nil {
+			return nil, err
+		}
+		return p.ast.Expressions.NewIncrementExpr(target, operator, true), nil
+	}
+
+	if p.match(ast.BANG, ast.MINUS, ast.TILDE) {
 		operator := p.previous()
 		child, err := p.parseUnary()
 		if err != nil {
 			return child, err
 		}
-		return p.ast.Expressions.NewUnaryExpr(operator, child), nil
+		return p.ast.Expressions.NewUnaryExpr(operator, child.ID()), nil
 	}
 
-	return p.parseCall()
+	return p.parsePower()
 }
 
-// call           -> primary ( "(" arguments? ")" )* ;
-// arguments      -> expression ( ", " expression )* ;
-func (p *Parser) parseCall() (ast.Expr, error) {
-	callee, err := p.parsePrimary()
+// power          -> postfix ( "**" unary )? ;
+// The exponent is parsed as a unary, which makes "**" right-associative and lets it bind tighter than a unary
+// operator on its left, so -2 ** 2 is -(2 ** 2)
+func (p *Parser) parsePower() (ast.Expr, error) {
+	expr, err := p.parsePostfix()
 	if err != nil {
-		return callee, err
+		return expr, err
 	}
 
-	for p.match(ast.LEFT_PAREN) {
-		args := make([]ast.Expr, 0)
+	if p.match(ast.STAR_STAR) {
+		operator := p.previous()
 
-		// empty argument list
-		if p.match(ast.RIGHT_PAREN) {
-			callee = p.ast.Expressions.NewCallExpr(p.previous(), callee, args)
-			continue
+		right, err := p.parseUnary()
+		if err != nil {
+			return expr, err
 		}
 
-		// first argument
-		arg, err := p.parseAssignment()
-		if err != nil {
-			return callee, err
+		expr = p.ast.Expressions.NewBinaryExpr(operator, expr.ID(), right.ID())
+	}
+
+	return expr, nil
+}
+
+// postfix        -> IDENTIFIER ( "++" | "--" ) | call ;
+func (p *Parser) parsePostfix() (ast.Expr, error) {
+	expr, err := p.parseCall()
+	if err != nil {
+		return expr, err
+	}
+
+	if p.match(ast.PLUS_PLUS, ast.MINUS_MINUS) {
+		operator := p.previous()
+		if target, ok := expr.(*ast.IdentifierExpr); ok {
+			return p.ast.Expressions.NewIncrementExpr(target.Token, operator, false), nil
 		}
-		args = append(args, arg)
+		return expr, util.NewSyntaxError(operator, fmt.Sprintf("operand of '%s' is not an identifier.", operator.Lexeme))
+	}
 
-		// additional arguments
-		for p.match(ast.COMMA) {
-			if len(args) >= 255 {
-				return callee, util.NewSyntaxError(p.peek(), "can't have more than 255 arguments.")
+	return expr, nil
+}
+
+// call           -> primary ( "(" arguments? ")" | "." IDENTIFIER )* ;
+func (p *Parser) parseCall() (ast.Expr, error) {
+	callee, err := p.parsePrimary()
+	if err != nil {
+		return callee, err
+	}
+
+	for {
+		if p.match(ast.LEFT_PAREN) {
+			callee, err = p.finishCall(callee)
+			if err != nil {
+				return callee, err
 			}
-			arg, err := p.parseAssignment()
+		} else if p.match(ast.DOT) {
+			name, err := p.consume(ast.IDENTIFIER, "expected property name after '.'.")
 			if err != nil {
 				return callee, err
 			}
-			args = append(args, arg)
+			callee = p.ast.Expressions.NewGetExpr(callee.ID(), name)
+		} else {
+			return callee, nil
 		}
+	}
+}
+
+// arguments      -> assignment ( "," assignment )* ;
+// Parses the argument list of a call after its opening '('
+func (p *Parser) finishCall(callee ast.Expr) (ast.Expr, error) {
+	args := make([]ast.ExprID, 0)
+
+	// empty argument list
+	if p.match(ast.RIGHT_PAREN) {
+		return p.ast.Expressions.NewCallExpr(p.previous(), callee.ID(), args), nil
+	}
+
+	// first argument
+	arg, err := p.parseAssignment()
+	if err != nil {
+		return callee, err
+	}
+	args = append(args, arg.ID())
 
-		close, err := p.consume(ast.RIGHT_PAREN, "expected ')' after argument list.")
+	// additional arguments
+	for p.match(ast.COMMA) {
+		if len(args) >= 255 {
+			return callee, util.NewSyntaxError(p.peek(), "can't have more than 255 arguments.")
+		}
+		arg, err := p.parseAssignment()
 		if err != nil {
 			return callee, err
 		}
+		args = append(args, arg.ID())
+	}
 
-		callee = p.ast.Expressions.NewCallExpr(close, callee, args)
+	close, err := p.consume(ast.RIGHT_PAREN, "expected ')' after argument list.")
+	if err != nil {
+		return callee, err
 	}
 
-	return callee, nil
+	return p.ast.Expressions.NewCallExpr(close, callee.ID(), args), nil
 }
 
-// primary        → NUMBER | STRING | IDENTIFIER | "true" | "false" | "nil" | "(" expression ")" ;
+// primary        → NUMBER | STRING | IDENTIFIER | "true" | "false" | "nil" | "fun" functionBody
+// | "(" expression ")" ;
 func (p *Parser) parsePrimary() (ast.Expr, error) {
 	if p.match(ast.NUMBER, ast.STRING, ast.TRUE, ast.FALSE, ast.NIL) {
 		return p.ast.Expressions.NewLiteralExpr(p.previous()), nil
@@ -637,12 +1134,22 @@ func (p *Parser) parsePrimary() (ast.Expr, error) {
 		return p.ast.Expressions.NewIdentifierExpr(p.previous()), nil
 	}
 
+	if p.match(ast.FUN) {
+		keyword := p.previous()
+		function, errs := p.parseFunctionBody()
+		if errs != nil {
+			// Expressions only report a single error
+			return nil, errs[0]
+		}
+		return p.ast.Expressions.NewFunctionExpr(keyword, function), nil
+	}
+
 	if p.match(ast.LEFT_PAREN) {
 		expr, err := p.parseExpression()
 		if err != nil {
 			return expr, err
 		}
-		expr = p.ast.Expressions.NewGroupingExpr(expr)
+		expr = p.ast.Expressions.NewGroupingExpr(expr.ID())
 		_, err = p.consume(ast.RIGHT_PAREN, "expected ')' after expression.")
 
 		return expr, err
@@ -681,6 +1188,14 @@ func (p Parser) check(token ast.TokenType) bool {
 	return p.peek().Type == token
 }
 
+// Returns true if the ast.Token after the current one is of the given ast.TokenType
+func (p Parser) checkNext(token ast.TokenType) bool {
+	if p.isAtEnd() {
+		return false
+	}
+	return p.tokens[p.current+1].Type == token
+}
+
 // Returns the next ast.Token without consuming it
 func (p Parser) peek() ast.Token {
 	return p.tokens[p.current]
@@ -729,10 +1244,16 @@ func (p *Parser) skipToNextStatement() {
 			fallthrough
 		case ast.WHILE:
 			fallthrough
+		case ast.SWITCH:
+			fallthrough
 		case ast.PRINT:
 			fallthrough
 		case ast.RETURN:
 			fallthrough
+		case ast.THROW:
+			fallthrough
+		case ast.TRY:
+			fallthrough
 		case ast.LEFT_BRACE:
 			return
 		}
@@ -748,3 +1269,19 @@ func (p *Parser) incLoopLevel() {
 func (p *Parser) decLoopLevel() {
 	p.loopLevel -= 1
 }
+
+// Returns the ID of a child statement that may be absent, like the else branch of an if statement, or 0
+func optionalStmt(stmt ast.Stmt) ast.StmtID {
+	if stmt == nil {
+		return 0
+	}
+	return stmt.ID()
+}
+
+// Returns the ID of a child expression that may be absent, like the increment of a for loop, or 0
+func optionalExpr(expr ast.Expr) ast.ExprID {
+	if expr == nil {
+		return 0
+	}
+	return expr.ID()
+}
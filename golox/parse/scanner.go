@@ -1,16 +1,29 @@
 package parse
 
 import (
+	"fmt"
 	"strconv"
+	"strings"
 	"toterich/golox/ast"
 	"toterich/golox/util"
-	"toterich/golox/util/assert"
 )
 
 func isDigit(c byte) bool {
 	return c >= '0' && c <= '9'
 }
 
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isOctalDigit(c byte) bool {
+	return c >= '0' && c <= '7'
+}
+
+func isBinaryDigit(c byte) bool {
+	return c == '0' || c == '1'
+}
+
 func isAlpha(c byte) bool {
 	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z' || c == '_')
 }
@@ -26,6 +39,13 @@ type Scanner struct {
 	source  string
 	tokens  []ast.Token
 	errs    []error
+	strings *ast.StringTable
+}
+
+// Creates a Scanner that interns identifiers and string literals in the given table. It needs to be the table of
+// the interpreter that executes the scanned program.
+func NewScanner(strings *ast.StringTable) Scanner {
+	return Scanner{strings: strings}
 }
 
 func (s *Scanner) ScanTokens(source string) ([]ast.Token, []error) {
@@ -35,6 +55,9 @@ func (s *Scanner) ScanTokens(source string) ([]ast.Token, []error) {
 	s.source = source
 	s.tokens = make([]ast.Token, 0)
 	s.errs = nil
+	if s.strings == nil {
+		s.strings = ast.NewStringTable()
+	}
 
 	for !s.isAtEnd() {
 		s.start = s.current
@@ -57,11 +80,49 @@ func (s *Scanner) ScanTokens(source string) ([]ast.Token, []error) {
 		case ';':
 			s.addToken(ast.SEMICOLON)
 		case '-':
-			s.addToken(ast.MINUS)
+			if s.match('-') {
+				s.addToken(ast.MINUS_MINUS)
+			} else if s.match('=') {
+				s.addToken(ast.MINUS_EQUAL)
+			} else {
+				s.addToken(ast.MINUS)
+			}
 		case '+':
-			s.addToken(ast.PLUS)
+			if s.match('+') {
+				s.addToken(ast.PLUS_PLUS)
+			} else if s.match('=') {
+				s.addToken(ast.PLUS_EQUAL)
+			} else {
+				s.addToken(ast.PLUS)
+			}
 		case '*':
-			s.addToken(ast.STAR)
+			if s.match('*') {
+				s.addToken(ast.STAR_STAR)
+			} else if s.match('=') {
+				s.addToken(ast.STAR_EQUAL)
+			} else {
+				s.addToken(ast.STAR)
+			}
+		case '%':
+			s.addToken(ast.PERCENT)
+		case '&':
+			s.addToken(ast.AMPERSAND)
+		case '|':
+			s.addToken(ast.PIPE)
+		case '^':
+			s.addToken(ast.CARET)
+		case '~':
+			// ~ followed by a comment is a plain TILDE
+			if s.peek() == '/' && s.peekNext() != '/' && s.peekNext() != '*' {
+				s.current += 1
+				s.addToken(ast.TILDE_SLASH)
+			} else {
+				s.addToken(ast.TILDE)
+			}
+		case '?':
+			s.addToken(ast.QUESTION)
+		case ':':
+			s.addToken(ast.COLON)
 		case '!':
 			if s.match('=') {
 				s.addToken(ast.BANG_EQUAL)
@@ -77,12 +138,16 @@ func (s *Scanner) ScanTokens(source string) ([]ast.Token, []error) {
 		case '<':
 			if s.match('=') {
 				s.addToken(ast.LESS_EQUAL)
+			} else if s.match('<') {
+				s.addToken(ast.LESS_LESS)
 			} else {
 				s.addToken(ast.LESS)
 			}
 		case '>':
 			if s.match('=') {
 				s.addToken(ast.GREATER_EQUAL)
+			} else if s.match('>') {
+				s.addToken(ast.GREATER_GREATER)
 			} else {
 				s.addToken(ast.GREATER)
 			}
@@ -95,6 +160,8 @@ func (s *Scanner) ScanTokens(source string) ([]ast.Token, []error) {
 			} else if s.match('*') {
 				// Block Comments
 				s.matchBlockComment()
+			} else if s.match('=') {
+				s.addToken(ast.SLASH_EQUAL)
 			} else {
 				s.addToken(ast.SLASH)
 			}
@@ -179,37 +246,151 @@ func (s *Scanner) matchString() {
 
 	t := s.generateToken(ast.STRING)
 	// Store normalized String with ast.Token
-	t.Literal = ast.NewStringValue(s.source[s.start+1 : s.current-1])
+	t.Literal = ast.NewStringValue(s.strings.Intern(s.source[s.start+1 : s.current-1]))
 	s.tokens = append(s.tokens, t)
 }
 
+// Number literals are either decimal, with an optional fraction and exponent (1, 1.5, 6.02E23, 1e-9),
+// or integers with a base prefix (0xFF, 0o17, 0b1010). In both cases, single underscores may be
+// used to separate digits (1_000_000).
 func (s *Scanner) matchNumber() {
-	for isDigit(s.peek()) {
-		s.current += 1
+	if s.source[s.start] == '0' {
+		switch s.peek() {
+		case 'x', 'X':
+			s.current += 1
+			s.matchRadixNumber(16, "hexadecimal", isHexDigit)
+			return
+		case 'o', 'O':
+			s.current += 1
+			s.matchRadixNumber(8, "octal", isOctalDigit)
+			return
+		case 'b', 'B':
+			s.current += 1
+			s.matchRadixNumber(2, "binary", isBinaryDigit)
+			return
+		}
 	}
 
+	// The first digit has already been consumed, rewind so it is included in the digit sequence
+	s.current = s.start
+	_, separatorsOk := s.consumeDigits(isDigit)
+
 	if s.peek() == '.' && isDigit(s.peekNext()) {
 		// Consume '.'
 		s.current += 1
 
 		// Consume all fractional digits
-		for isDigit(s.peek()) {
+		_, ok := s.consumeDigits(isDigit)
+		separatorsOk = separatorsOk && ok
+	}
+
+	if s.peek() == 'e' || s.peek() == 'E' {
+		// Consume 'e' and optional sign
+		s.current += 1
+		if s.peek() == '+' || s.peek() == '-' {
 			s.current += 1
 		}
+
+		numDigits, ok := s.consumeDigits(isDigit)
+		separatorsOk = separatorsOk && ok
+		if numDigits == 0 && !isAlphaNumeric(s.peek()) {
+			s.addError(s.line, s.peek(), "exponent has no digits.")
+			return
+		}
+	}
+
+	if s.skipInvalidDigits("number") || !s.checkSeparators(separatorsOk) {
+		return
 	}
 
 	t := s.generateToken(ast.NUMBER)
 
-	// Store actual numberic value with ast.Token
-	num, err := strconv.ParseFloat(t.Lexeme, 64)
-	// If this triggers, the number parsing above has a bug
-	assert.AssertNoError(err)
+	// Store actual numeric value with ast.Token
+	num, err := strconv.ParseFloat(strings.ReplaceAll(t.Lexeme, "_", ""), 64)
+	if err != nil {
+		s.addError(s.line, s.source[s.start], "number literal out of range.")
+		return
+	}
 
 	t.Literal = ast.NewNumberValue(num)
 
 	s.tokens = append(s.tokens, t)
 }
 
+// Matches the digits of an integer literal with the given base, after its prefix has been consumed.
+func (s *Scanner) matchRadixNumber(base int, name string, isValidDigit func(byte) bool) {
+	digitsStart := s.current
+	numDigits, separatorsOk := s.consumeDigits(isValidDigit)
+
+	if s.skipInvalidDigits(name) || !s.checkSeparators(separatorsOk) {
+		return
+	}
+	if numDigits == 0 {
+		s.addError(s.line, s.source[s.current-1], fmt.Sprintf("%s literal has no digits.", name))
+		return
+	}
+
+	t := s.generateToken(ast.NUMBER)
+
+	num, err := strconv.ParseUint(strings.ReplaceAll(s.source[digitsStart:s.current], "_", ""), base, 64)
+	if err != nil {
+		s.addError(s.line, s.source[s.start], "number literal out of range.")
+		return
+	}
+
+	t.Literal = ast.NewNumberValue(float64(num))
+
+	s.tokens = append(s.tokens, t)
+}
+
+// Consumes a sequence of digits for which isValidDigit returns true, optionally separated by underscores.
+// Returns the number of digits consumed and whether every underscore was placed between two digits.
+func (s *Scanner) consumeDigits(isValidDigit func(byte) bool) (int, bool) {
+	numDigits := 0
+	separatorsOk := true
+	lastWasSeparator := false
+
+	for isValidDigit(s.peek()) || s.peek() == '_' {
+		if s.peek() == '_' {
+			if numDigits == 0 || lastWasSeparator {
+				separatorsOk = false
+			}
+			lastWasSeparator = true
+		} else {
+			numDigits += 1
+			lastWasSeparator = false
+		}
+		s.current += 1
+	}
+
+	return numDigits, separatorsOk && !lastWasSeparator
+}
+
+// A number literal must not be directly followed by letters or digits that are invalid for its base, e.g.
+// 0b102 or 12abc. If it is, all of them are consumed so that scanning can resume after the malformed literal,
+// an error is added and true is returned.
+func (s *Scanner) skipInvalidDigits(name string) bool {
+	if !isAlphaNumeric(s.peek()) {
+		return false
+	}
+
+	invalid := s.peek()
+	for isAlphaNumeric(s.peek()) {
+		s.current += 1
+	}
+
+	s.addError(s.line, invalid, fmt.Sprintf("invalid character '%c' in %s literal.", invalid, name))
+	return true
+}
+
+// Adds an error if the digit separators of the current number literal were misplaced.
+func (s *Scanner) checkSeparators(separatorsOk bool) bool {
+	if !separatorsOk {
+		s.addError(s.line, '_', "'_' must separate successive digits.")
+	}
+	return separatorsOk
+}
+
 func (s *Scanner) matchIdentifier() {
 	for isAlphaNumeric(s.peek()) {
 		s.current += 1
@@ -226,6 +407,8 @@ func (s *Scanner) matchIdentifier() {
 		} else if tokenType == ast.FALSE {
 			t.Literal = ast.NewBoolValue(false)
 		}
+	} else {
+		t.Symbol = s.strings.Intern(t.Lexeme)
 	}
 
 	s.tokens = append(s.tokens, t)
@@ -235,6 +418,11 @@ func (s *Scanner) matchBlockComment() {
 	nestingLevel := 1
 
 	for nestingLevel > 0 {
+		if s.isAtEnd() {
+			s.addError(s.line, '\x00', "Unterminated block comment.")
+			return
+		}
+
 		if s.peek() == '*' && s.peekNext() == '/' {
 			nestingLevel -= 1
 			s.current += 2
@@ -0,0 +1,91 @@
+package parse
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"toterich/golox/ast"
+)
+
+func TestScanNumbers(t *testing.T) {
+	tests := map[string]float64{
+		"0":           0,
+		"42":          42,
+		"3.25":        3.25,
+		"1_000_000":   1000000,
+		"1.5e3":       1500,
+		"2E-2":        0.02,
+		"1_0e+1_0":    1e11,
+		"0x1F":        31,
+		"0XfF":        255,
+		"0o17":        15,
+		"0b1010":      10,
+		"0b1111_0000": 240,
+	}
+
+	for source, expected := range tests {
+		scanner := NewScanner(ast.NewStringTable())
+		tokens, errs := scanner.ScanTokens(source)
+		if errs != nil {
+			t.Errorf("%q: %v", source, errs)
+			continue
+		}
+		if len(tokens) != 2 || tokens[0].Type != ast.NUMBER || tokens[0].Literal.AsNumber() != expected {
+			t.Errorf("%q: expected the number %v, got %v", source, expected, tokens)
+		}
+	}
+}
+
+func TestScanNumberErrors(t *testing.T) {
+	tests := map[string]string{
+		"0x":                      "hexadecimal literal has no digits.",
+		"0o":                      "octal literal has no digits.",
+		"0b":                      "binary literal has no digits.",
+		"0b2":                     "invalid character '2' in binary literal.",
+		"0o8":                     "invalid character '8' in octal literal.",
+		"0x1g":                    "invalid character 'g' in hexadecimal literal.",
+		"12abc":                   "invalid character 'a' in number literal.",
+		"1_":                      "'_' must separate successive digits.",
+		"1__0":                    "'_' must separate successive digits.",
+		"0x_1":                    "'_' must separate successive digits.",
+		"1.5_":                    "'_' must separate successive digits.",
+		"1e":                      "exponent has no digits.",
+		"1e+":                     "exponent has no digits.",
+		"1e400":                   "number literal out of range.",
+		"0x1_0000_0000_0000_0000": "number literal out of range.",
+	}
+
+	for source, expected := range tests {
+		scanner := NewScanner(ast.NewStringTable())
+		_, errs := scanner.ScanTokens(source)
+		if len(errs) != 1 || !strings.HasSuffix(errs[0].Error(), expected) {
+			t.Errorf("%q: expected error %q, got %v", source, expected, errs)
+		}
+	}
+}
+
+// ~/ is floor division, unless the slash starts a comment
+func TestScanTilde(t *testing.T) {
+	tests := map[string][]ast.TokenType{
+		"4 ~/ 2":          {ast.NUMBER, ast.TILDE_SLASH, ast.NUMBER, ast.EOF},
+		"~1":              {ast.TILDE, ast.NUMBER, ast.EOF},
+		"~// comment\n1":  {ast.TILDE, ast.NUMBER, ast.EOF},
+		"~/* comment */1": {ast.TILDE, ast.NUMBER, ast.EOF},
+	}
+
+	for source, expected := range tests {
+		scanner := NewScanner(ast.NewStringTable())
+		tokens, errs := scanner.ScanTokens(source)
+		if errs != nil {
+			t.Errorf("%q: %v", source, errs)
+			continue
+		}
+		var types []ast.TokenType
+		for _, token := range tokens {
+			types = append(types, token.Type)
+		}
+		if !slices.Equal(types, expected) {
+			t.Errorf("%q: expected tokens %v, got %v", source, expected, tokens)
+		}
+	}
+}
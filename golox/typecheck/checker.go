@@ -0,0 +1,428 @@
+package typecheck
+
+import (
+	"fmt"
+	"toterich/golox/ast"
+	"toterich/golox/util"
+	"toterich/golox/util/assert"
+)
+
+// A static type checker for Lox programs with optional type annotations.
+// The types of expressions are inferred as far as possible and verified wherever an annotation or an operator
+// constrains them. Variables and parameters without annotation have type Any, so unannotated programs are
+// never rejected for what they assign.
+// Scopes follow the interpreter's: function bodies only see their own variables and the global ones, not those
+// of the functions they are nested in.
+type Checker struct {
+	tree       *ast.Ast
+	types      ast.SideTable[ast.ExprID, Type] // Inferred type of each expression checked
+	scopes     []scope
+	returnType Type // Declared return type of the function currently being checked
+	errs       []error
+}
+
+// Checks the given program and returns all type errors found, along with the type inferred for each of its
+// expressions
+func Check(tree *ast.Ast) (ast.SideTable[ast.ExprID, Type], []error) {
+	c := Checker{tree: tree, scopes: []scope{{types: map[string]Type{}}}, returnType: anyType}
+	c.declare("channel", Type{Kind: FUNCTION, Signature: &Signature{Params: []Type{numberType}, Return: channelType}})
+
+	for _, stmt := range tree.Body {
+		c.checkStmt(stmt)
+	}
+
+	return c.types, c.errs
+}
+
+func (c *Checker) checkStmt(id ast.StmtID) {
+	switch stmt := c.tree.Stmt(id).(type) {
+
+	case *ast.ExprStmt:
+		c.checkExpr(stmt.Expr)
+
+	case *ast.PrintStmt:
+		c.checkExpr(stmt.Expr)
+
+	case *ast.VarDeclStmt:
+		declared := c.resolveAnnotation(stmt.Type)
+		if stmt.Value != 0 {
+			value := c.checkExpr(stmt.Value)
+			if !value.assignableTo(declared) {
+				c.addError(stmt.Identifier,
+					fmt.Sprintf("cannot initialize variable '%s' of type %s with value of type %s.", stmt.Identifier.Lexeme, declared, value))
+			}
+		} else if !nilType.assignableTo(declared) {
+			// Variables without initializer are nil
+			c.addError(stmt.Identifier,
+				fmt.Sprintf("variable '%s' of type %s needs to be initialized.", stmt.Identifier.Lexeme, declared))
+		}
+		c.declare(stmt.Identifier.Lexeme, declared)
+
+	case *ast.BlockStmt:
+		c.push(false)
+		for _, child := range stmt.Body {
+			c.checkStmt(child)
+		}
+		c.pop()
+
+	case *ast.IfStmt:
+		c.checkExpr(stmt.Condition)
+		c.checkStmt(stmt.Then)
+		if stmt.Else != 0 {
+			c.checkStmt(stmt.Else)
+		}
+
+	case *ast.WhileStmt:
+		c.checkExpr(stmt.Condition)
+		c.checkStmt(stmt.Then)
+		if stmt.Increment != 0 {
+			c.checkExpr(stmt.Increment)
+		}
+
+	case *ast.SwitchStmt:
+		c.checkExpr(stmt.Value)
+		for _, case_ := range stmt.Cases {
+			for _, value := range case_.Values {
+				c.checkExpr(value)
+			}
+			c.checkStmt(case_.Body)
+		}
+		if stmt.Default != 0 {
+			c.checkStmt(stmt.Default)
+		}
+
+	case *ast.BreakStmt:
+	case *ast.ContinueStmt:
+
+	case *ast.ReturnStmt:
+		value := nilType
+		if stmt.Value != 0 {
+			value = c.checkExpr(stmt.Value)
+		}
+		if !value.assignableTo(c.returnType) {
+			c.addError(stmt.Keyword,
+				fmt.Sprintf("cannot return value of type %s from function returning %s.", value, c.returnType))
+		}
+
+	case *ast.ThrowStmt:
+		c.checkExpr(stmt.Value)
+
+	case *ast.TryStmt:
+		c.checkStmt(stmt.Body)
+		if stmt.Catch != 0 {
+			// Anything can be thrown, so the caught value can't be typed
+			c.push(false)
+			c.declare(stmt.CatchName.Lexeme, anyType)
+			c.checkStmt(stmt.Catch)
+			c.pop()
+		}
+		if stmt.Finally != 0 {
+			c.checkStmt(stmt.Finally)
+		}
+
+	case *ast.FunDeclStmt:
+		type_ := c.functionType(&stmt.FunctionBody)
+		// Declare the function before checking its body to allow recursion
+		c.declare(stmt.Name.Lexeme, type_)
+		c.checkFunctionBody(&stmt.FunctionBody, type_.Signature)
+
+	default:
+		panic(assert.MissingCase(stmt))
+	}
+}
+
+// Infers the type of the given expression and records it, adding errors for all operands whose types don't fit
+func (c *Checker) checkExpr(id ast.ExprID) Type {
+	type_ := c.inferExpr(c.tree.Expr(id))
+	c.types.Set(id, type_)
+	return type_
+}
+
+func (c *Checker) inferExpr(expr ast.Expr) Type {
+	switch expr := expr.(type) {
+
+	case *ast.LiteralExpr:
+		return literalType(expr.Token)
+
+	case *ast.IdentifierExpr:
+		return c.lookup(expr.Token.Lexeme)
+
+	case *ast.UnaryExpr:
+		operand := c.checkExpr(expr.Operand)
+		switch expr.Operator.Type {
+		case ast.MINUS, ast.TILDE:
+			c.expectOperands(expr.Operator, numberType, operand)
+			return numberType
+		case ast.BANG:
+			return boolType
+		}
+		panic(assert.MissingCase(expr.Operator.Type))
+
+	case *ast.BinaryExpr:
+		left := c.checkExpr(expr.Left)
+		right := c.checkExpr(expr.Right)
+		return c.binaryType(expr.Operator, expr.Operator.Type, left, right)
+
+	case *ast.SequenceExpr:
+		type_ := anyType
+		for _, expr := range expr.Exprs {
+			type_ = c.checkExpr(expr)
+		}
+		return type_
+
+	case *ast.ConditionalExpr:
+		c.checkExpr(expr.Condition)
+		return commonType(c.checkExpr(expr.Then), c.checkExpr(expr.Else))
+
+	case *ast.GroupingExpr:
+		return c.checkExpr(expr.Grouped)
+
+	case *ast.AssignExpr:
+		value := c.checkExpr(expr.Value)
+		c.expectAssignable(expr.Target, value)
+		return value
+
+	case *ast.CompoundAssignExpr:
+		value := c.checkExpr(expr.Value)
+		operator, ok := compoundOperators[expr.Operator.Type]
+		if !ok {
+			panic(assert.MissingCase(expr.Operator.Type))
+		}
+		result := c.binaryType(expr.Operator, operator, c.lookup(expr.Target.Lexeme), value)
+		c.expectAssignable(expr.Target, result)
+		return result
+
+	case *ast.IncrementExpr:
+		c.expectOperands(expr.Operator, numberType, c.lookup(expr.Target.Lexeme))
+		return numberType
+
+	// Logical operators evaluate to one of their operands
+	case *ast.OrExpr:
+		return commonType(c.checkExpr(expr.Left), c.checkExpr(expr.Right))
+
+	case *ast.AndExpr:
+		return commonType(c.checkExpr(expr.Left), c.checkExpr(expr.Right))
+
+	case *ast.CallExpr:
+		return c.checkCall(expr)
+
+	case *ast.GetExpr:
+		object := c.checkExpr(expr.Object)
+		switch object.Kind {
+		case ANY:
+			return anyType
+		case ERROR:
+			switch expr.Name.Lexeme {
+			case "message":
+				return stringType
+			case "line":
+				return numberType
+			}
+			c.addError(expr.Name, fmt.Sprintf("undefined property '%s'.", expr.Name.Lexeme))
+		case CHANNEL:
+			switch expr.Name.Lexeme {
+			case "send":
+				return Type{Kind: FUNCTION, Signature: &Signature{Params: []Type{anyType}, Return: nilType}}
+			case "receive":
+				return Type{Kind: FUNCTION, Signature: &Signature{Return: anyType}}
+			case "close":
+				return Type{Kind: FUNCTION, Signature: &Signature{Return: nilType}}
+			}
+			c.addError(expr.Name, fmt.Sprintf("undefined property '%s'.", expr.Name.Lexeme))
+		default:
+			c.addError(expr.Name, fmt.Sprintf("value of type %s has no properties.", object))
+		}
+		return anyType
+
+	case *ast.FunctionExpr:
+		type_ := c.functionType(&expr.FunctionBody)
+		c.checkFunctionBody(&expr.FunctionBody, type_.Signature)
+		return type_
+
+	case *ast.SpawnExpr:
+		c.checkExpr(expr.Call)
+		return taskType
+
+	case *ast.AwaitExpr:
+		task := c.checkExpr(expr.Task)
+		if !task.assignableTo(taskType) {
+			c.addError(expr.Keyword, fmt.Sprintf("operand of 'await' is a %s, not a Task.", task))
+		}
+		return anyType
+
+	default:
+		panic(assert.MissingCase(expr))
+	}
+}
+
+// Maps the operator of a compound assignment to the binary operator it applies
+var compoundOperators = map[ast.TokenType]ast.TokenType{
+	ast.PLUS_EQUAL:  ast.PLUS,
+	ast.MINUS_EQUAL: ast.MINUS,
+	ast.STAR_EQUAL:  ast.STAR,
+	ast.SLASH_EQUAL: ast.SLASH,
+}
+
+// Returns the result type of applying the binary operator of the given type to operands of the given types.
+// token is the location errors are reported at.
+func (c *Checker) binaryType(token ast.Token, operator ast.TokenType, left Type, right Type) Type {
+	switch operator {
+	case ast.PLUS:
+		// Either two Numbers or two Strings. If only one operand type is known, the other one has to match it.
+		for _, type_ := range []Type{numberType, stringType} {
+			if left.assignableTo(type_) && right.assignableTo(type_) {
+				if left.Kind == ANY && right.Kind == ANY {
+					return anyType
+				}
+				return type_
+			}
+		}
+		c.addError(token, fmt.Sprintf("expected either [Number Number] or [String String] as operands, got [%s %s].", left, right))
+		return anyType
+	case ast.MINUS, ast.SLASH, ast.STAR, ast.STAR_STAR, ast.PERCENT, ast.TILDE_SLASH,
+		ast.AMPERSAND, ast.PIPE, ast.CARET, ast.LESS_LESS, ast.GREATER_GREATER:
+		c.expectOperands(token, numberType, left, right)
+		return numberType
+	case ast.GREATER, ast.GREATER_EQUAL, ast.LESS, ast.LESS_EQUAL:
+		c.expectOperands(token, numberType, left, right)
+		return boolType
+	case ast.BANG_EQUAL, ast.EQUAL_EQUAL:
+		return boolType
+	}
+
+	panic(assert.MissingCase(operator))
+}
+
+func (c *Checker) checkCall(expr *ast.CallExpr) Type {
+	callee := c.checkExpr(expr.Callee)
+
+	var args []Type
+	for _, arg := range expr.Arguments {
+		args = append(args, c.checkExpr(arg))
+	}
+
+	if callee.Kind == ANY || (callee.Kind == FUNCTION && callee.Signature == nil) {
+		return anyType
+	}
+	if callee.Kind != FUNCTION {
+		c.addError(expr.Location, fmt.Sprintf("value of type %s is not callable.", callee))
+		return anyType
+	}
+
+	params := callee.Signature.Params
+	if len(params) != len(args) {
+		c.addError(expr.Location, fmt.Sprintf("callee expects %d arguments, got %d.", len(params), len(args)))
+	} else {
+		for idx, arg := range args {
+			if !arg.assignableTo(params[idx]) {
+				c.addError(expr.Location,
+					fmt.Sprintf("argument %d has type %s, expected %s.", idx+1, arg, params[idx]))
+			}
+		}
+	}
+
+	return callee.Signature.Return
+}
+
+// Returns the type of a function from its annotations, unannotated parameters and return values have type Any
+func (c *Checker) functionType(function *ast.FunctionBody) Type {
+	signature := Signature{Return: c.resolveAnnotation(function.ReturnType)}
+	for _, paramType := range function.ParamTypes {
+		signature.Params = append(signature.Params, c.resolveAnnotation(paramType))
+	}
+	return Type{Kind: FUNCTION, Signature: &signature}
+}
+
+func (c *Checker) checkFunctionBody(function *ast.FunctionBody, signature *Signature) {
+	c.push(true)
+	defer c.pop()
+
+	for idx, param := range function.Params {
+		c.declare(param.Lexeme, signature.Params[idx])
+	}
+
+	returnType := c.returnType
+	c.returnType = signature.Return
+	for _, stmt := range function.Body {
+		c.checkStmt(stmt)
+	}
+	c.returnType = returnType
+
+	// Falling off the end of a function returns nil
+	if !nilType.assignableTo(signature.Return) && !alwaysReturns(c.tree, function.Body...) {
+		c.addError(function.ReturnType.Name,
+			fmt.Sprintf("function returning %s doesn't return a value on every path.", signature.Return))
+	}
+}
+
+// Returns the type named by the annotation, or Any if there is none
+func (c *Checker) resolveAnnotation(annotation *ast.TypeAnnotation) Type {
+	if annotation == nil {
+		return anyType
+	}
+
+	type_, ok := typeNames[annotation.Name.Lexeme]
+	if !ok {
+		c.addError(annotation.Name, fmt.Sprintf("unknown type '%s'.", annotation.Name.Lexeme))
+		return anyType
+	}
+	return type_
+}
+
+// Adds an error if any of the operand types can't be used where expected is required
+func (c *Checker) expectOperands(operator ast.Token, expected Type, operands ...Type) {
+	for _, operand := range operands {
+		if !operand.assignableTo(expected) {
+			c.addError(operator, fmt.Sprintf("expected %s as operand, got %s.", expected, operand))
+			return
+		}
+	}
+}
+
+// Adds an error if a value of the given type can't be assigned to the target variable
+func (c *Checker) expectAssignable(target ast.Token, value Type) {
+	declared := c.lookup(target.Lexeme)
+	if !value.assignableTo(declared) {
+		c.addError(target,
+			fmt.Sprintf("cannot assign value of type %s to variable '%s' of type %s.", value, target.Lexeme, declared))
+	}
+}
+
+func (c *Checker) addError(token ast.Token, msg string) {
+	c.errs = append(c.errs, util.NewTypeError(token, msg))
+}
+
+type scope struct {
+	types map[string]Type
+	root  bool // If true, this scope only inherits from the global scope, like the scope of a function call
+}
+
+func (c *Checker) push(root bool) {
+	c.scopes = append(c.scopes, scope{types: map[string]Type{}, root: root})
+}
+
+func (c *Checker) pop() {
+	c.scopes = c.scopes[:len(c.scopes)-1]
+}
+
+func (c *Checker) declare(name string, type_ Type) {
+	c.scopes[len(c.scopes)-1].types[name] = type_
+}
+
+// Returns the declared type of a variable. Variables that can't be found, e.g. because they are declared
+// further below in the program, have type Any.
+func (c *Checker) lookup(name string) Type {
+	// The global scope is the first one, nested scopes are searched up to the innermost root scope
+	for i := len(c.scopes) - 1; i > 0; i -= 1 {
+		if type_, ok := c.scopes[i].types[name]; ok {
+			return type_
+		}
+		if c.scopes[i].root {
+			break
+		}
+	}
+	if type_, ok := c.scopes[0].types[name]; ok {
+		return type_
+	}
+	return anyType
+}
@@ -0,0 +1,143 @@
+package typecheck
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"toterich/golox/ast"
+	"toterich/golox/parse"
+)
+
+func parseSource(source string) (*ast.Ast, []error) {
+	scanner := parse.NewScanner(ast.NewStringTable())
+	tokens, errs := scanner.ScanTokens(source)
+	if errs != nil {
+		return nil, errs
+	}
+	var parser parse.Parser
+	return parser.Parse(tokens)
+}
+
+func TestCheckErrors(t *testing.T) {
+	tests := map[string]string{
+		`var x: Number = "one";`:                                `Type Error at line 1: cannot initialize variable 'x' of type Number with value of type String.`,
+		`var x: Number;`:                                        `Type Error at line 1: variable 'x' of type Number needs to be initialized.`,
+		`var x: Numbr = 1;`:                                     `Type Error at line 1: unknown type 'Numbr'.`,
+		`var x: Number = 1; x = "one";`:                         `Type Error at line 1: cannot assign value of type String to variable 'x' of type Number.`,
+		`var s: String = "a"; print s * 2;`:                     `Type Error at line 1: expected Number as operand, got String.`,
+		`print -"a";`:                                           `Type Error at line 1: expected Number as operand, got String.`,
+		`print 1 + "one";`:                                      `Type Error at line 1: expected either [Number Number] or [String String] as operands, got [Number String].`,
+		`print (true or false) < 1;`:                            `Type Error at line 1: expected Number as operand, got Bool.`,
+		`var f: Number = 1; f();`:                               `Type Error at line 1: value of type Number is not callable.`,
+		`fun f(a: Number) {} f("a");`:                           `Type Error at line 1: argument 1 has type String, expected Number.`,
+		`fun f(a, b) {} f(1);`:                                  `Type Error at line 1: callee expects 2 arguments, got 1.`,
+		`fun f(): Number { return "a"; }`:                       `Type Error at line 1: cannot return value of type String from function returning Number.`,
+		`fun f(): Number {}`:                                    `Type Error at line 1: function returning Number doesn't return a value on every path.`,
+		`fun f(a): Number { if (a) return 1; }`:                 `Type Error at line 1: function returning Number doesn't return a value on every path.`,
+		`fun f(): Number { while (true) { break; } }`:           `Type Error at line 1: function returning Number doesn't return a value on every path.`,
+		`fun f(a): Number { switch (a) { case 1: return 1; } }`: `Type Error at line 1: function returning Number doesn't return a value on every path.`,
+		`var g = fun (): String { print 1; };`:                  `Type Error at line 1: function returning String doesn't return a value on every path.`,
+		`print (1).message;`:                                    `Type Error at line 1: value of type Number has no properties.`,
+		`var x: String = "a"; fun f() { print x * 2; }`:         `Type Error at line 1: expected Number as operand, got String.`,
+		`print await 1;`:                                        `Type Error at line 1: operand of 'await' is a Number, not a Task.`,
+		"var x: Number = 1;\nvar y: String = x;":                `Type Error at line 2: cannot initialize variable 'y' of type String with value of type Number.`,
+
+		// Programs without errors
+		`var x: Number = 1; print x + 1;`:       "",
+		`var x: Nil; var y: Any;`:               "",
+		`var x; x = 1; x = "one";`:              "",
+		`var x = 1; x = "a"; print x + "b";`:    "",
+		`{ var x: String = "a"; } print x * 2;`: "",
+		// Functions don't see the variables of the functions they are nested in
+		`fun f() { var x: String = "a"; fun g() { print x * 2; } }`: "",
+		`var x = nil; x = 1;`:                                                        "",
+		`fun f(a) { return a; } print f(1) + 1;`:                                     "",
+		`var s = nil or "default"; print s + 1;`:                                     "",
+		`fun f(a): Number { if (a) return 1; else return 2; }`:                       "",
+		`fun f(): Number { while (true) { if (1 > 2) return 1; } }`:                  "",
+		`fun f(): Number { for (;;) { while (true) break; } }`:                       "",
+		`fun f(a): Number { switch (a) { case 1: return 1; default: throw "no"; } }`: "",
+		`fun f(): Number { try { return 1; } catch (e) { return 2; } }`:              "",
+		`fun f(): Number { try { print 1; } finally { return 2; } }`:                 "",
+		`fun f(): Nil {} fun g(): Any {} fun h() {}`:                                 "",
+	}
+
+	for source, expected := range tests {
+		tree, errs := parseSource(source)
+		if errs != nil {
+			t.Fatalf("%q: %v", source, errs)
+		}
+		_, errs = Check(tree)
+		var actual []string
+		for _, err := range errs {
+			actual = append(actual, err.Error())
+		}
+		if strings.Join(actual, "\n") != expected {
+			t.Errorf("%q: expected errors %q, got %q", source, expected, actual)
+		}
+	}
+}
+
+// Every expression checked is assigned its inferred type, including those in function bodies
+func TestCheckRecordsTypes(t *testing.T) {
+	tree, errs := parseSource(`var n: Number = 1;
+print n + 2;
+print "a" + "b";
+fun f() { print n < 2; }
+print f;`)
+	if errs != nil {
+		t.Fatal(errs)
+	}
+	types, errs := Check(tree)
+	if errs != nil {
+		t.Fatal(errs)
+	}
+
+	var printed []string
+	for _, stmt := range tree.Body {
+		ast.Inspect(tree, tree.Stmt(stmt), func(node ast.Node) bool {
+			switch node := node.(type) {
+			case *ast.PrintStmt:
+				type_, _ := types.Get(node.Expr)
+				printed = append(printed, type_.String())
+			case ast.Expr:
+				if _, ok := types.Get(node.ID()); !ok {
+					t.Errorf("no type recorded for %T", node)
+				}
+			}
+			return true
+		})
+	}
+
+	expected := []string{"Number", "String", "Bool", "Function(): Any"}
+	if !slices.Equal(printed, expected) {
+		t.Errorf("expected the printed types %v, got %v", expected, printed)
+	}
+}
+
+// Samples only use annotations correctly, so the checker needs to accept them
+func TestCheckSamples(t *testing.T) {
+	files, err := filepath.Glob("../../lox_spec/samples/*.lox")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tree, errs := parseSource(string(data))
+			if errs != nil {
+				// Samples of features that haven't been implemented yet
+				t.Skip(errs)
+			}
+			if _, errs := Check(tree); errs != nil {
+				t.Error(errs)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package typecheck
+
+import "toterich/golox/ast"
+
+// Returns true if executing the statements in order never completes normally, because every path through them
+// ends in a return or throw statement, or in an infinite loop. Any statement may throw at runtime, so this
+// only tells whether control can reach the end of the statements without an explicit return.
+func alwaysReturns(tree *ast.Ast, stmts ...ast.StmtID) bool {
+	for _, stmt := range stmts {
+		switch stmt := tree.Stmt(stmt).(type) {
+		case *ast.ReturnStmt, *ast.ThrowStmt:
+			return true
+		case *ast.BlockStmt:
+			if alwaysReturns(tree, stmt.Body...) {
+				return true
+			}
+		case *ast.IfStmt:
+			if stmt.Else != 0 && alwaysReturns(tree, stmt.Then) && alwaysReturns(tree, stmt.Else) {
+				return true
+			}
+		case *ast.WhileStmt:
+			// Only loops whose condition is the literal true, including for loops without condition, are known
+			// to run until they are broken out of
+			literal, ok := tree.Expr(stmt.Condition).(*ast.LiteralExpr)
+			if ok && literal.Token.Type == ast.TRUE && !breaks(tree, stmt.Then) {
+				return true
+			}
+		case *ast.SwitchStmt:
+			if stmt.Default != 0 && alwaysReturns(tree, stmt.Default) && !breaks(tree, stmt.Default) && switchCasesReturn(tree, stmt.Cases) {
+				return true
+			}
+		case *ast.TryStmt:
+			if stmt.Finally != 0 && alwaysReturns(tree, stmt.Finally) {
+				return true
+			}
+			if alwaysReturns(tree, stmt.Body) && (stmt.Catch == 0 || alwaysReturns(tree, stmt.Catch)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func switchCasesReturn(tree *ast.Ast, cases []ast.SwitchCase) bool {
+	for _, case_ := range cases {
+		if !alwaysReturns(tree, case_.Body) || breaks(tree, case_.Body) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if stmt contains a break statement that leaves the loop or switch stmt is part of
+func breaks(tree *ast.Ast, stmt ast.StmtID) bool {
+	found := false
+	ast.Inspect(tree, tree.Stmt(stmt), func(node ast.Node) bool {
+		switch node.(type) {
+		case *ast.BreakStmt:
+			found = true
+		// Breaks in nested loops and switches leave those, and functions can't be broken out of
+		case *ast.WhileStmt, *ast.SwitchStmt, *ast.FunDeclStmt, *ast.FunctionExpr:
+			return false
+		}
+		return !found
+	})
+	return found
+}
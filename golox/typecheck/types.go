@@ -0,0 +1,135 @@
+package typecheck
+
+import (
+	"strings"
+	"toterich/golox/ast"
+)
+
+type Kind int
+
+// The kinds of static types. Any is the type of every expression whose type can't be inferred, it is compatible
+// with all other types.
+const (
+	ANY Kind = iota
+	NIL
+	NUMBER
+	STRING
+	BOOL
+	FUNCTION
+	ERROR
+	TASK
+	CHANNEL
+)
+
+// A static type. Signature is only set for functions whose parameter and return types are known.
+type Type struct {
+	Kind      Kind
+	Signature *Signature
+}
+
+type Signature struct {
+	Params []Type
+	Return Type
+}
+
+var (
+	anyType     = Type{Kind: ANY}
+	nilType     = Type{Kind: NIL}
+	numberType  = Type{Kind: NUMBER}
+	stringType  = Type{Kind: STRING}
+	boolType    = Type{Kind: BOOL}
+	errorType   = Type{Kind: ERROR}
+	taskType    = Type{Kind: TASK}
+	channelType = Type{Kind: CHANNEL}
+)
+
+// Maps the names used in type annotations to their types
+var typeNames = map[string]Type{
+	"Any":      anyType,
+	"Nil":      nilType,
+	"Number":   numberType,
+	"String":   stringType,
+	"Bool":     boolType,
+	"Function": {Kind: FUNCTION},
+	"Error":    errorType,
+	"Task":     taskType,
+	"Channel":  channelType,
+}
+
+func (t Type) String() string {
+	switch t.Kind {
+	case ANY:
+		return "Any"
+	case NIL:
+		return "Nil"
+	case NUMBER:
+		return "Number"
+	case STRING:
+		return "String"
+	case BOOL:
+		return "Bool"
+	case ERROR:
+		return "Error"
+	case TASK:
+		return "Task"
+	case CHANNEL:
+		return "Channel"
+	}
+
+	if t.Signature == nil {
+		return "Function"
+	}
+	params := make([]string, len(t.Signature.Params))
+	for idx, param := range t.Signature.Params {
+		params[idx] = param.String()
+	}
+	return "Function(" + strings.Join(params, ", ") + "): " + t.Signature.Return.String()
+}
+
+// Returns true if a value of type t can be used where a value of type target is expected
+func (t Type) assignableTo(target Type) bool {
+	if t.Kind == ANY || target.Kind == ANY {
+		return true
+	}
+	if t.Kind != target.Kind {
+		return false
+	}
+	if t.Kind != FUNCTION || t.Signature == nil || target.Signature == nil {
+		return true
+	}
+
+	// Functions need to agree on their signature, parameters are compared in the opposite direction
+	if len(t.Signature.Params) != len(target.Signature.Params) {
+		return false
+	}
+	for idx, param := range t.Signature.Params {
+		if !target.Signature.Params[idx].assignableTo(param) {
+			return false
+		}
+	}
+	return t.Signature.Return.assignableTo(target.Signature.Return)
+}
+
+// Returns the static type of a literal token
+func literalType(token ast.Token) Type {
+	switch token.Type {
+	case ast.NUMBER:
+		return numberType
+	case ast.STRING:
+		return stringType
+	case ast.TRUE, ast.FALSE:
+		return boolType
+	case ast.NIL:
+		return nilType
+	}
+	return anyType
+}
+
+// Returns the type of an expression that evaluates to a value of either type. It is only known if both types
+// agree on it.
+func commonType(a Type, b Type) Type {
+	if a.Kind == b.Kind && a.Signature == nil && b.Signature == nil {
+		return a
+	}
+	return anyType
+}
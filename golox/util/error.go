@@ -36,46 +36,147 @@ func (e SyntaxError) Error() string {
 	return fmt.Sprintf("Syntax Error at line %d: %s", e.Token.Line, e.Msg)
 }
 
+// A TypeError found by the static type checker before a program is executed
+type TypeError struct {
+	Token ast.Token
+	Msg   string
+}
+
+func NewTypeError(token ast.Token, msg string) TypeError {
+	return TypeError{Token: token, Msg: msg}
+}
+
+func (e TypeError) Error() string {
+	return fmt.Sprintf("Type Error at line %d: %s", e.Token.Line, e.Msg)
+}
+
+// A function call that was active when an error occurred. Line is the line of the call expression.
+type StackFrame struct {
+	Function string
+	Line     int
+}
+
 // A RuntimeError indicating an issue with executing Lox Code
 type RuntimeError struct {
 	Token ast.Token
 	Msg   string
+	Cause error        // Optional, allows distinguishing kinds of runtime errors with errors.Is()
+	Trace []StackFrame // Innermost call first
 }
 
 func NewRuntimeError(token ast.Token, msg string) RuntimeError {
 	return RuntimeError{Token: token, Msg: msg}
 }
 
+// Creates a RuntimeError whose message is given by cause
+func NewRuntimeErrorWithCause(token ast.Token, cause error) RuntimeError {
+	return RuntimeError{Token: token, Msg: cause.Error(), Cause: cause}
+}
+
 func (e RuntimeError) Error() string {
 	return fmt.Sprintf("Runtime Error at line %d: %s", e.Token.Line, e.Msg)
 }
 
-func LogErrors(errs ...error) {
-	for _, err := range errs {
-		{
-			var e LexError
-			if errors.As(err, &e) {
-				log.Printf("[line %d] Lexical Error at Char '%c': %s", e.Line, e.Char, e.Msg)
-				continue
+func (e RuntimeError) Unwrap() error {
+	return e.Cause
+}
+
+// A value thrown by a throw statement, which is propagated until it is caught by a try statement
+type LoxException struct {
+	Token ast.Token
+	Value ast.LoxValue
+	Trace []StackFrame // Innermost call first
+}
+
+func NewLoxException(token ast.Token, value ast.LoxValue) LoxException {
+	return LoxException{Token: token, Value: value}
+}
+
+func (e LoxException) Error() string {
+	return fmt.Sprintf("Uncaught exception at line %d: %s", e.Token.Line, e.Value)
+}
+
+// Traces longer than this, e.g. from runaway recursion, are logged with the frames in between omitted
+const maxLoggedFrames = 20
+
+// Logs the active function calls at the time of an error, given the line the error occurred at
+func logStackTrace(line int, trace []StackFrame) {
+	if len(trace) == 0 {
+		return
+	}
+
+	// Each frame was called from the line the next outer frame is currently at
+	for idx, frame := range trace {
+		if len(trace) <= maxLoggedFrames || idx < maxLoggedFrames/2 || idx >= len(trace)-maxLoggedFrames/2 {
+			log.Printf("    [line %d] in %s()", line, frame.Function)
+		} else if idx == maxLoggedFrames/2 {
+			log.Printf("    ... %d more calls", len(trace)-maxLoggedFrames)
+		}
+		line = frame.Line
+	}
+	log.Printf("    [line %d] in script", line)
+}
+
+// Returns the message an error is reported with, without any stack trace
+func FormatError(err error) string {
+	{
+		var e LexError
+		if errors.As(err, &e) {
+			return fmt.Sprintf("[line %d] Lexical Error at Char '%c': %s", e.Line, e.Char, e.Msg)
+		}
+	}
+
+	{
+		var e SyntaxError
+		if errors.As(err, &e) {
+			return fmt.Sprintf("[line %d] Syntax Error at Token '%s': %s", e.Token.Line, e.Token.Lexeme, e.Msg)
+		}
+	}
+
+	{
+		var e TypeError
+		if errors.As(err, &e) {
+			return fmt.Sprintf("[line %d] Type Error at '%s': %s", e.Token.Line, e.Token.Lexeme, e.Msg)
+		}
+	}
+
+	{
+		var e RuntimeError
+		if errors.As(err, &e) {
+			if e.Token.Lexeme == "" {
+				// Errors that aren't caused by a specific token, e.g. exceeding a resource limit
+				return fmt.Sprintf("[line %d] Runtime Error: %s", e.Token.Line, e.Msg)
 			}
+			return fmt.Sprintf("[line %d] Runtime Error at '%s': %s", e.Token.Line, e.Token.Lexeme, e.Msg)
+		}
+	}
+
+	{
+		var e LoxException
+		if errors.As(err, &e) {
+			return fmt.Sprintf("[line %d] Uncaught exception: %s", e.Token.Line, e.Value)
 		}
+	}
+
+	return err.Error()
+}
+
+func LogErrors(errs ...error) {
+	for _, err := range errs {
+		log.Print(FormatError(err))
 
 		{
-			var e SyntaxError
+			var e RuntimeError
 			if errors.As(err, &e) {
-				log.Printf("[line %d] Syntax Error at Token '%s': %s", e.Token.Line, e.Token.Lexeme, e.Msg)
-				continue
+				logStackTrace(e.Token.Line, e.Trace)
 			}
 		}
 
 		{
-			var e RuntimeError
+			var e LoxException
 			if errors.As(err, &e) {
-				log.Printf("[line %d] Runtime Error at '%s': %s", e.Token.Line, e.Token.Lexeme, e.Msg)
-				continue
+				logStackTrace(e.Token.Line, e.Trace)
 			}
 		}
-
-		log.Println(err)
 	}
 }
@@ -0,0 +1,25 @@
+// Package fuzzseed seeds the corpora of fuzz targets with Lox scripts.
+package fuzzseed
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Adds every Lox script in the given directories as a seed, so the fuzzer starts from valid programs
+func AddScripts(f *testing.F, dirs ...string) {
+	for _, dir := range dirs {
+		files, err := filepath.Glob(filepath.Join(dir, "*.lox"))
+		if err != nil {
+			f.Fatal(err)
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(string(data))
+		}
+	}
+}